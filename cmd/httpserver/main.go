@@ -1,11 +1,20 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/dragonicorn/httpfromtcp/internal/digest"
 	"github.com/dragonicorn/httpfromtcp/internal/headers"
 	"github.com/dragonicorn/httpfromtcp/internal/request"
 	"github.com/dragonicorn/httpfromtcp/internal/response"
@@ -14,6 +23,50 @@ import (
 
 const port = 42069
 
+var (
+	assets  = &server.FileServer{Root: "./assets", Prefix: "/assets", IndexFiles: []string{"index.html"}}
+	httpbin = &server.ReverseProxy{
+		Upstream:       &url.URL{Scheme: "https", Host: "httpbin.org"},
+		Prefix:         "/httpbin",
+		ModifyResponse: addContentTrailers,
+		DigestTrailers: []digest.Algorithm{digest.SHA256},
+	}
+	metrics = server.NewMetrics()
+)
+
+// trailerBody fills in the X-Content-SHA256 and X-Content-Length trailers of
+// res once its body has been read to the end. They predate Content-Digest and
+// are kept for existing clients.
+type trailerBody struct {
+	io.ReadCloser
+	res    *http.Response
+	digest *digest.Digest
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.digest.Write(p[:n])
+	if err == io.EOF {
+		b.res.Trailer.Set("X-Content-SHA256", fmt.Sprintf("%x", b.digest.Sum()))
+		b.res.Trailer.Set("X-Content-Length", fmt.Sprintf("%d", b.digest.Length()))
+	}
+	return n, err
+}
+
+func addContentTrailers(res *http.Response) error {
+	d, err := digest.New(digest.SHA256)
+	if err != nil {
+		return err
+	}
+	if res.Trailer == nil {
+		res.Trailer = http.Header{}
+	}
+	res.Trailer["X-Content-Sha256"] = nil
+	res.Trailer["X-Content-Length"] = nil
+	res.Body = &trailerBody{ReadCloser: res.Body, res: res, digest: d}
+	return nil
+}
+
 func handler(w *response.Writer, req *request.Request) error {
 	var (
 		err error
@@ -21,6 +74,23 @@ func handler(w *response.Writer, req *request.Request) error {
 		msg string
 		sc  response.StatusCode
 	)
+	// digests in algorithms the server does not implement are ignored
+	err = req.VerifyContentDigest()
+	if errors.Is(err, digest.ErrMismatch) || errors.Is(err, digest.ErrMalformedField) {
+		return w.WriteError(response.StatusCode400, nil)
+	}
+	if req.RequestLine.RequestTarget == "/metrics" {
+		return metrics.Handler(w, req)
+	}
+	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
+		return httpbin.Handler(w, req)
+	}
+	if strings.HasPrefix(req.RequestLine.RequestTarget, "/video") {
+		return assets.ServeFile(w, req, "vim.mp4")
+	}
+	if strings.HasPrefix(req.RequestLine.RequestTarget, "/assets/") {
+		return assets.Handler(w, req)
+	}
 	if req.RequestLine.RequestTarget == "/yourproblem" {
 		sc = response.StatusCode400
 		msg = "<html><head><title>400 Bad Request</title></head><body><h1>Bad Request</h1><p>Your request honestly kinda sucked.</p></body></html>\n"
@@ -44,7 +114,34 @@ func handler(w *response.Writer, req *request.Request) error {
 }
 
 func main() {
-	server, err := server.Serve(port, handler)
+	var (
+		debug     = flag.Bool("debug", false, "log request parser traces")
+		accessLog = flag.String("access-log", "", "write a Combined Log Format access log to this file")
+		level     = slog.LevelInfo
+		opts      = []server.Option{
+			server.WithMetrics(metrics),
+			server.WithCompression(response.NewCompression()),
+			server.WithRequestDecoding(10 << 20),
+		}
+	)
+	flag.Parse()
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	headers.SetLogger(logger)
+	request.SetLogger(logger)
+	server.SetLogger(logger)
+	if *accessLog != "" {
+		f, err := server.NewRotatingFile(*accessLog, 10<<20, 5)
+		if err != nil {
+			log.Fatalf("Error opening access log: %v", err)
+		}
+		defer f.Close()
+		opts = append(opts, server.WithAccessLog(server.NewAccessLog(f, server.CombinedLogFormat)))
+	}
+
+	server, err := server.Serve(port, handler, opts...)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
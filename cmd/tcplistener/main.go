@@ -100,8 +100,8 @@ func main() {
 		os.Exit(1)
 	}
 	fmt.Printf("Request line:\n- Method: %s\n- Target: %s\n- Version: %s\nHeaders:\n", r.RequestLine.Method, r.RequestLine.RequestTarget, r.RequestLine.HttpVersion)
-	for k, v := range r.Headers {
-		fmt.Printf("- %s: %s\n", k, v)
+	for _, f := range r.Headers {
+		fmt.Printf("- %s: %s\n", f.Name, f.Value)
 	}
 	fmt.Printf("Body:\n%s\n", string(r.Body))
 	// for line := range getLinesChannel(c) {
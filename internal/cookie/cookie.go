@@ -0,0 +1,183 @@
+// Package cookie parses the Cookie request header and builds Set-Cookie
+// response headers as specified by RFC 6265 and its updates.
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrInvalidCookie = errors.New("invalid cookie")
+	ErrNoCookie      = errors.New("no such cookie")
+)
+
+type SameSite string
+
+const (
+	SameSiteStrict SameSite = "Strict"
+	SameSiteLax    SameSite = "Lax"
+	SameSiteNone   SameSite = "None"
+)
+
+// Cookie is a cookie received in a Cookie header, of which only Name and Value
+// are set, or one to send in a Set-Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain  string
+	Path    string
+	Expires time.Time // zero for no Expires attribute
+	// MaxAge is the lifetime in seconds. Zero means no Max-Age attribute and a
+	// negative value deletes the cookie, sending "Max-Age=0".
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite // empty for no SameSite attribute
+	Partitioned bool
+}
+
+// validValue reports whether s consists of cookie-octets, optionally enclosed
+// in double quotes.
+func validValue(s string) bool {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// validAttribute reports whether s may be the value of an attribute such as
+// Path: any characters but controls and ';'.
+func validAttribute(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] == 0x7f || s[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// validDomain reports whether s is a host name or IP address, with an
+// optional leading dot.
+func validDomain(s string) bool {
+	s = strings.TrimPrefix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Valid checks that c can be sent in a Set-Cookie header and will be accepted
+// by browsers, including the rules for the __Secure- and __Host- name
+// prefixes and for SameSite=None and Partitioned cookies.
+func (c *Cookie) Valid() error {
+	switch {
+	case c.Name == "" || !headers.ValidateString(c.Name):
+		return fmt.Errorf("%w (name) - %q", ErrInvalidCookie, c.Name)
+	case !validValue(c.Value):
+		return fmt.Errorf("%w (value of %s) - %q", ErrInvalidCookie, c.Name, c.Value)
+	case c.Domain != "" && !validDomain(c.Domain):
+		return fmt.Errorf("%w (domain of %s) - %q", ErrInvalidCookie, c.Name, c.Domain)
+	case !validAttribute(c.Path):
+		return fmt.Errorf("%w (path of %s) - %q", ErrInvalidCookie, c.Name, c.Path)
+	case !c.Expires.IsZero() && c.Expires.Year() < 1601:
+		return fmt.Errorf("%w (expires of %s) - %v", ErrInvalidCookie, c.Name, c.Expires)
+	}
+	switch c.SameSite {
+	case "", SameSiteStrict, SameSiteLax:
+	case SameSiteNone:
+		if !c.Secure {
+			return fmt.Errorf("%w (%s) - SameSite=None requires Secure", ErrInvalidCookie, c.Name)
+		}
+	default:
+		return fmt.Errorf("%w (SameSite of %s) - %q", ErrInvalidCookie, c.Name, c.SameSite)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("%w (%s) - Partitioned requires Secure", ErrInvalidCookie, c.Name)
+	}
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		return fmt.Errorf("%w (%s) - the __Secure- prefix requires Secure", ErrInvalidCookie, c.Name)
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Domain != "" || c.Path != "/") {
+		return fmt.Errorf("%w (%s) - the __Host- prefix requires Secure, Path=/ and no Domain", ErrInvalidCookie, c.Name)
+	}
+	return nil
+}
+
+// String returns the Set-Cookie value for c. It does not validate c.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + headers.FormatTime(c.Expires))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.SameSite != "" {
+		b.WriteString("; SameSite=" + string(c.SameSite))
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse parses the value of a Cookie request header, "name=value" pairs
+// separated by semicolons. Malformed pairs are skipped and double quotes
+// around a value removed.
+func Parse(s string) []*Cookie {
+	var (
+		cookies     []*Cookie
+		name, value string
+		ok          bool
+	)
+	for _, pair := range strings.Split(s, ";") {
+		name, value, ok = strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || !headers.ValidateString(name) || !validValue(value) {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
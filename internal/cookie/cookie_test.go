@@ -0,0 +1,86 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs are split on semicolons and quotes removed
+	cookies := Parse(`a=1; b="two";c=; d=x=y`)
+	assert.Equal(t, []*Cookie{
+		{Name: "a", Value: "1"},
+		{Name: "b", Value: "two"},
+		{Name: "c", Value: ""},
+		{Name: "d", Value: "x=y"},
+	}, cookies)
+
+	// Test: Malformed pairs are skipped
+	cookies = Parse(`novalue; =anon; sp ace=1; v=a b; q="x"y; ok=yes;;`)
+	assert.Equal(t, []*Cookie{{Name: "ok", Value: "yes"}}, cookies)
+
+	// Test: Empty header
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	// Test: Name and value only
+	assert.Equal(t, "id=42", (&Cookie{Name: "id", Value: "42"}).String())
+
+	// Test: All attributes
+	c := &Cookie{
+		Name:        "__Host-session",
+		Value:       "abc",
+		Path:        "/",
+		Expires:     time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	assert.NoError(t, c.Valid())
+	assert.Equal(t, "__Host-session=abc; Path=/; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=None; Partitioned", c.String())
+
+	// Test: A negative MaxAge deletes the cookie and a leading dot is dropped
+	c = &Cookie{Name: "id", Domain: ".example.com", MaxAge: -1}
+	assert.Equal(t, "id=; Domain=example.com; Max-Age=0", c.String())
+}
+
+func TestValid(t *testing.T) {
+	// Test: Valid cookies
+	for _, c := range []*Cookie{
+		{Name: "id", Value: "42"},
+		{Name: "id", Value: `"quoted"`},
+		{Name: "id", Value: "", Domain: "sub.example.com", Path: "/app"},
+		{Name: "id", Value: "x", SameSite: SameSiteLax},
+		{Name: "__Secure-id", Value: "x", Secure: true, Domain: "example.com"},
+	} {
+		assert.NoError(t, c.Valid(), c.String())
+	}
+
+	// Test: Invalid cookies
+	for _, c := range []*Cookie{
+		{Name: "", Value: "x"},
+		{Name: "a b", Value: "x"},
+		{Name: "id", Value: "a b"},
+		{Name: "id", Value: "a;b"},
+		{Name: "id", Value: "a,b"},
+		{Name: "id", Value: `a"b`},
+		{Name: "id", Value: "é"},
+		{Name: "id", Domain: "exa mple.com"},
+		{Name: "id", Domain: "-example.com"},
+		{Name: "id", Path: "/a;b"},
+		{Name: "id", Expires: time.Date(1600, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "id", SameSite: "Sometimes"},
+		{Name: "id", SameSite: SameSiteNone},
+		{Name: "id", Partitioned: true},
+		{Name: "__Secure-id"},
+		{Name: "__Host-id", Secure: true, Path: "/app"},
+		{Name: "__Host-id", Secure: true, Path: "/", Domain: "example.com"},
+	} {
+		assert.ErrorIs(t, c.Valid(), ErrInvalidCookie, c.String())
+	}
+}
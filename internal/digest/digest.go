@@ -0,0 +1,157 @@
+// Package digest computes and checks the integrity fields of RFC 9530:
+// Content-Digest, Repr-Digest and their use as trailers.
+package digest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha-256"
+	SHA512 Algorithm = "sha-512"
+	CRC32C Algorithm = "crc32c"
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported digest algorithm")
+	ErrMalformedField       = errors.New("malformed digest field")
+	ErrMismatch             = errors.New("digest mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func newHash(alg Algorithm) (hash.Hash, error) {
+	switch alg {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case CRC32C:
+		return crc32.New(castagnoli), nil
+	}
+	return nil, fmt.Errorf("%w - %s", ErrUnsupportedAlgorithm, alg)
+}
+
+// Digest hashes the bytes written to it incrementally.
+type Digest struct {
+	Algorithm Algorithm
+
+	hash hash.Hash
+	n    int64
+}
+
+func New(alg Algorithm) (*Digest, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	return &Digest{Algorithm: alg, hash: h}, nil
+}
+
+// Of returns the digest of data.
+func Of(alg Algorithm, data []byte) (*Digest, error) {
+	d, err := New(alg)
+	if err != nil {
+		return nil, err
+	}
+	d.Write(data)
+	return d, nil
+}
+
+func (d *Digest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.hash.Write(p)
+}
+
+// Sum returns the digest of the bytes written so far.
+func (d *Digest) Sum() []byte {
+	return d.hash.Sum(nil)
+}
+
+// Length returns the number of bytes written so far.
+func (d *Digest) Length() int64 {
+	return d.n
+}
+
+// Field formats digests as the value of a Content-Digest or Repr-Digest field,
+// e.g. "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:".
+func Field(digests ...*Digest) string {
+	var members []string
+	for _, d := range digests {
+		members = append(members, string(d.Algorithm)+"=:"+base64.StdEncoding.EncodeToString(d.Sum())+":")
+	}
+	return strings.Join(members, ", ")
+}
+
+// ParseField parses a Content-Digest or Repr-Digest value into the digest
+// bytes for each algorithm. Parameters of the members are ignored.
+func ParseField(value string) (map[Algorithm][]byte, error) {
+	var (
+		err    error
+		sums   = make(map[Algorithm][]byte)
+		sum    []byte
+		key, v string
+		ok     bool
+	)
+	for _, member := range strings.Split(value, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		key, v, ok = strings.Cut(member, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w - %s", ErrMalformedField, member)
+		}
+		v, _, _ = strings.Cut(v, ";")
+		v = strings.TrimSpace(v)
+		if len(v) < 2 || v[0] != ':' || v[len(v)-1] != ':' {
+			return nil, fmt.Errorf("%w - %s", ErrMalformedField, member)
+		}
+		sum, err = base64.StdEncoding.DecodeString(v[1 : len(v)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%w - %s", ErrMalformedField, member)
+		}
+		sums[Algorithm(strings.ToLower(strings.TrimSpace(key)))] = sum
+	}
+	return sums, nil
+}
+
+// Verify checks data against a Content-Digest or Repr-Digest value. Every
+// supported algorithm present must match and at least one must be present.
+func Verify(value string, data []byte) error {
+	var (
+		checked int
+		d       *Digest
+		err     error
+		sums    map[Algorithm][]byte
+	)
+	sums, err = ParseField(value)
+	if err != nil {
+		return err
+	}
+	for alg, sum := range sums {
+		d, err = Of(alg, data)
+		if err != nil {
+			// unknown algorithms are ignored as long as another one is known
+			continue
+		}
+		if !bytes.Equal(d.Sum(), sum) {
+			return fmt.Errorf("%w - %s", ErrMismatch, alg)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("%w - %s", ErrUnsupportedAlgorithm, value)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package digest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestField(t *testing.T) {
+	var (
+		d1, d2 *Digest
+		err    error
+	)
+	// Test: Examples from RFC 9530
+	d1, err = Of(SHA256, []byte(`{"hello": "world"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", Field(d1))
+	d2, err = Of(SHA512, []byte(`{"hello": "world"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:, "+
+		"sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:", Field(d1, d2))
+
+	// Test: CRC32C check value
+	d1, err = Of(CRC32C, []byte("123456789"))
+	require.NoError(t, err)
+	assert.Equal(t, "crc32c=:4waSgw==:", Field(d1))
+
+	// Test: Incremental writes
+	d1, err = New(SHA256)
+	require.NoError(t, err)
+	d1.Write([]byte(`{"hello": `))
+	d1.Write([]byte(`"world"}`))
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", Field(d1))
+	assert.Equal(t, int64(18), d1.Length())
+
+	// Test: Unsupported algorithm
+	_, err = New("md5")
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"hello": "world"}`)
+
+	// Test: Matching digest
+	assert.NoError(t, Verify("sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", body))
+
+	// Test: Unknown algorithms and parameters are ignored
+	assert.NoError(t, Verify("md5=:AAAA:, SHA-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:;x=1", body))
+
+	// Test: Mismatching digest
+	assert.ErrorIs(t, Verify("sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", []byte("{}")), ErrMismatch)
+
+	// Test: One mismatching algorithm fails
+	assert.ErrorIs(t, Verify("sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:, crc32c=:AAAAAA==:", body), ErrMismatch)
+
+	// Test: No supported algorithm
+	assert.ErrorIs(t, Verify("md5=:AAAA:", body), ErrUnsupportedAlgorithm)
+
+	// Test: Malformed fields
+	assert.ErrorIs(t, Verify("sha-256", body), ErrMalformedField)
+	assert.ErrorIs(t, Verify("sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=", body), ErrMalformedField)
+	assert.ErrorIs(t, Verify("sha-256=:not base64!:", body), ErrMalformedField)
+}
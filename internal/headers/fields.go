@@ -0,0 +1,519 @@
+package headers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrInvalidValue = errors.New("invalid field value")
+
+// TimeFormat is the IMF-fixdate layout HTTP dates are sent in.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// layouts accepted for HTTP dates: IMF-fixdate, then the obsolete RFC 850 and
+// asctime formats
+var timeLayouts = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ParseTime parses an HTTP date in any of the formats recipients must accept.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w (date) - %s", ErrInvalidValue, s)
+}
+
+// FormatTime formats t as an IMF-fixdate.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// Time parses the date held by the field named key.
+func (h Headers) Time(key string) (time.Time, error) {
+	return ParseTime(h.Get(key))
+}
+
+// ContentLength returns the value of Content-Length, or -1 if there is none.
+// Repeated identical values, as some intermediaries produce, are accepted.
+func (h Headers) ContentLength() (int64, error) {
+	var (
+		cl  int64 = -1
+		err error
+		n   int64
+	)
+	for _, v := range h.List("Content-Length") {
+		if strings.Trim(v, "0123456789") != "" {
+			return -1, fmt.Errorf("%w (Content-Length) - %s", ErrInvalidValue, v)
+		}
+		n, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || (cl >= 0 && n != cl) {
+			return -1, fmt.Errorf("%w (Content-Length) - %s", ErrInvalidValue, h.Get("Content-Length"))
+		}
+		cl = n
+	}
+	return cl, nil
+}
+
+// splitQuoted splits s at every sep outside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		escaped bool
+		parts   []string
+		quoted  bool
+		start   int
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// SplitList splits a comma-separated list field value into its elements.
+// Commas inside quoted strings do not separate elements, and empty elements
+// are dropped.
+func SplitList(s string) []string {
+	var elems []string
+	for _, elem := range splitQuoted(s, ',') {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// List returns the elements of the list held by all fields named key.
+func (h Headers) List(key string) []string {
+	var elems []string
+	for _, v := range h.Values(key) {
+		elems = append(elems, SplitList(v)...)
+	}
+	return elems
+}
+
+// Unquote returns the content of a quoted string with its escapes removed. A
+// token is returned as it is.
+func Unquote(s string) (string, error) {
+	var (
+		b       strings.Builder
+		escaped bool
+	)
+	if !strings.HasPrefix(s, "\"") {
+		return s, nil
+	}
+	if len(s) < 2 || !strings.HasSuffix(s, "\"") {
+		return "", fmt.Errorf("%w (unterminated quoted string) - %s", ErrInvalidValue, s)
+	}
+	for _, r := range s[1 : len(s)-1] {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+		if !escaped && r == '"' {
+			return "", fmt.Errorf("%w (unescaped quote) - %s", ErrInvalidValue, s)
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	if escaped {
+		return "", fmt.Errorf("%w (unterminated quoted string) - %s", ErrInvalidValue, s)
+	}
+	return b.String(), nil
+}
+
+// Quote returns s as a token if it is one, otherwise as a quoted string.
+func Quote(s string) string {
+	if s != "" && ValidateString(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// parseParams parses "name=value" parameters, the value being a token or a
+// quoted string. Names are lowercased.
+func parseParams(params []string) (map[string]string, error) {
+	var (
+		err   error
+		m     = make(map[string]string)
+		name  string
+		value string
+	)
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		name, value, _ = strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ValidateString(name) || name == "" {
+			return nil, fmt.Errorf("%w (parameter) - %s", ErrInvalidValue, param)
+		}
+		value, err = Unquote(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		m[name] = value
+	}
+	return m, nil
+}
+
+// MediaType is a parsed Content-Type or media range such as
+// "text/html; charset=utf-8".
+type MediaType struct {
+	Type    string
+	Subtype string
+	Params  map[string]string
+}
+
+// ParseMediaType parses a media type. Type, subtype and parameter names are
+// lowercased; parameter values are unquoted.
+func ParseMediaType(s string) (MediaType, error) {
+	var (
+		err   error
+		mt    MediaType
+		parts []string
+		ok    bool
+	)
+	parts = splitQuoted(s, ';')
+	mt.Type, mt.Subtype, ok = strings.Cut(strings.ToLower(strings.TrimSpace(parts[0])), "/")
+	if !ok || mt.Type == "" || mt.Subtype == "" || !ValidateString(mt.Type) || !ValidateString(mt.Subtype) {
+		return MediaType{}, fmt.Errorf("%w (media type) - %s", ErrInvalidValue, s)
+	}
+	mt.Params, err = parseParams(parts[1:])
+	if err != nil {
+		return MediaType{}, err
+	}
+	return mt, nil
+}
+
+// MediaType returns type/subtype without parameters.
+func (mt MediaType) MediaType() string {
+	return mt.Type + "/" + mt.Subtype
+}
+
+func (mt MediaType) String() string {
+	var b strings.Builder
+	b.WriteString(mt.MediaType())
+	for _, name := range slices.Sorted(maps.Keys(mt.Params)) {
+		b.WriteString("; " + name + "=" + Quote(mt.Params[name]))
+	}
+	return b.String()
+}
+
+// ContentType parses the Content-Type field.
+func (h Headers) ContentType() (MediaType, error) {
+	return ParseMediaType(h.Get("Content-Type"))
+}
+
+// AcceptItem is one element of an Accept, Accept-Language, Accept-Encoding or
+// Accept-Charset field.
+type AcceptItem struct {
+	// Value is the lowercased media range, language range, coding or charset.
+	Value string
+	// Q is the quality, from 0 (not acceptable) to 1.
+	Q float64
+	// Params holds any parameters other than q.
+	Params map[string]string
+}
+
+// ParseAccept parses an Accept-style field value into its elements ordered by
+// descending quality, elements of equal quality keeping their order. Invalid
+// qualities are taken as 0.
+func ParseAccept(s string) []AcceptItem {
+	var (
+		err   error
+		items []AcceptItem
+		parts []string
+	)
+	for _, elem := range SplitList(s) {
+		parts = splitQuoted(elem, ';')
+		item := AcceptItem{Value: strings.ToLower(strings.TrimSpace(parts[0])), Q: 1}
+		item.Params, err = parseParams(parts[1:])
+		if err != nil {
+			item.Params = map[string]string{}
+		}
+		if q, ok := item.Params["q"]; ok {
+			item.Q, err = strconv.ParseFloat(q, 64)
+			if err != nil || item.Q < 0 || item.Q > 1 {
+				item.Q = 0
+			}
+			delete(item.Params, "q")
+		}
+		items = append(items, item)
+	}
+	slices.SortStableFunc(items, func(a, b AcceptItem) int {
+		switch {
+		case a.Q > b.Q:
+			return -1
+		case a.Q < b.Q:
+			return 1
+		}
+		return 0
+	})
+	return items
+}
+
+// Accept parses the Accept field.
+func (h Headers) Accept() []AcceptItem {
+	return ParseAccept(h.Get("Accept"))
+}
+
+// AcceptLanguage parses the Accept-Language field.
+func (h Headers) AcceptLanguage() []AcceptItem {
+	return ParseAccept(h.Get("Accept-Language"))
+}
+
+// CacheControl maps Cache-Control directives, lowercased, to their arguments,
+// which are empty for directives without one.
+type CacheControl map[string]string
+
+// ParseCacheControl parses a Cache-Control field value. Malformed directives
+// are ignored.
+func ParseCacheControl(s string) CacheControl {
+	cc := make(CacheControl)
+	for _, elem := range SplitList(s) {
+		name, value, _ := strings.Cut(elem, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value, err := Unquote(strings.TrimSpace(value))
+		if err != nil || !ValidateString(name) {
+			continue
+		}
+		cc[name] = value
+	}
+	return cc
+}
+
+// Has reports whether the directive is present.
+func (cc CacheControl) Has(directive string) bool {
+	_, ok := cc[strings.ToLower(directive)]
+	return ok
+}
+
+// Duration returns the delta-seconds argument of a directive such as max-age.
+func (cc CacheControl) Duration(directive string) (time.Duration, bool) {
+	v, ok := cc[strings.ToLower(directive)]
+	if !ok || v == "" || strings.Trim(v, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n > int64(time.Duration(1<<63-1)/time.Second) {
+		// too large to represent - RFC 9111 says to treat it as "infinity"
+		return time.Duration(1<<63 - 1), true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func (cc CacheControl) String() string {
+	var elems []string
+	for _, name := range slices.Sorted(maps.Keys(cc)) {
+		if cc[name] == "" {
+			elems = append(elems, name)
+		} else {
+			elems = append(elems, name+"="+Quote(cc[name]))
+		}
+	}
+	return strings.Join(elems, ", ")
+}
+
+// CacheControl parses the Cache-Control field.
+func (h Headers) CacheControl() CacheControl {
+	return ParseCacheControl(h.Get("Cache-Control"))
+}
+
+// Credentials are the contents of an Authorization or Proxy-Authorization
+// field: a scheme followed by either a token68 or auth parameters.
+type Credentials struct {
+	Scheme string
+	Token  string
+	Params map[string]string
+}
+
+// isToken68 reports whether s has the token68 syntax used by schemes such as
+// Basic and Bearer.
+func isToken68(s string) bool {
+	body := strings.TrimRight(s, "=")
+	if body == "" {
+		return false
+	}
+	for _, r := range body {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~+/", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAuthorization parses credentials.
+func ParseAuthorization(s string) (Credentials, error) {
+	var (
+		c      Credentials
+		err    error
+		params []string
+		rest   string
+	)
+	c.Scheme, rest, _ = strings.Cut(strings.TrimSpace(s), " ")
+	if c.Scheme == "" || !ValidateString(c.Scheme) {
+		return Credentials{}, fmt.Errorf("%w (authorization scheme) - %s", ErrInvalidValue, s)
+	}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return c, nil
+	}
+	if isToken68(rest) {
+		c.Token = rest
+		return c, nil
+	}
+	params = SplitList(rest)
+	for _, param := range params {
+		if !strings.Contains(param, "=") {
+			return Credentials{}, fmt.Errorf("%w (auth-param) - %s", ErrInvalidValue, param)
+		}
+	}
+	c.Params, err = parseParams(params)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return c, nil
+}
+
+// BasicAuth returns the user name and password of Basic credentials.
+func (c Credentials) BasicAuth() (user, password string, ok bool) {
+	if !strings.EqualFold(c.Scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(c.Token)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// Authorization parses the Authorization field.
+func (h Headers) Authorization() (Credentials, error) {
+	return ParseAuthorization(h.Get("Authorization"))
+}
+
+// Disposition is a parsed Content-Disposition field.
+type Disposition struct {
+	// Type is the lowercased disposition type, such as "attachment" or
+	// "form-data".
+	Type   string
+	Params map[string]string
+}
+
+// unquoteLenient is like Unquote but keeps backslashes not followed by '"' or
+// '\', as browsers send Windows paths in file names without escaping them.
+func unquoteLenient(s string) (string, error) {
+	if !strings.HasPrefix(s, "\"") {
+		return s, nil
+	}
+	if len(s) < 2 || !strings.HasSuffix(s, "\"") {
+		return "", fmt.Errorf("%w (unterminated quoted string) - %s", ErrInvalidValue, s)
+	}
+	inner := s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) && (inner[i+1] == '"' || inner[i+1] == '\\') {
+			i++
+		} else if inner[i] == '"' {
+			return "", fmt.Errorf("%w (unescaped quote) - %s", ErrInvalidValue, s)
+		}
+		b.WriteByte(inner[i])
+	}
+	return b.String(), nil
+}
+
+// decodeExtValue decodes an RFC 8187 ext-value such as
+// "UTF-8'en'na%C3%AFve.txt".
+func decodeExtValue(s string) (string, bool) {
+	charset, rest, ok := strings.Cut(s, "'")
+	if !ok {
+		return "", false
+	}
+	_, encoded, ok := strings.Cut(rest, "'")
+	if !ok {
+		return "", false
+	}
+	decoded, err := url.PathUnescape(encoded)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(charset) {
+	case "utf-8":
+		return decoded, utf8.ValidString(decoded)
+	case "iso-8859-1":
+		var b strings.Builder
+		for i := 0; i < len(decoded); i++ {
+			b.WriteRune(rune(decoded[i]))
+		}
+		return b.String(), true
+	}
+	return "", false
+}
+
+// ParseContentDisposition parses a Content-Disposition field value. A
+// "filename*" parameter, in the RFC 8187 encoding, takes precedence over
+// "filename" and is returned as the "filename" parameter.
+func ParseContentDisposition(s string) (Disposition, error) {
+	var (
+		d     Disposition
+		name  string
+		parts []string
+		value string
+	)
+	parts = splitQuoted(s, ';')
+	d.Type = strings.ToLower(strings.TrimSpace(parts[0]))
+	if d.Type == "" || !ValidateString(d.Type) {
+		return Disposition{}, fmt.Errorf("%w (disposition type) - %s", ErrInvalidValue, s)
+	}
+	d.Params = make(map[string]string)
+	ext := make(map[string]string)
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		name, value, _ = strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || !ValidateString(name) {
+			return Disposition{}, fmt.Errorf("%w (parameter) - %s", ErrInvalidValue, param)
+		}
+		if base, ok := strings.CutSuffix(name, "*"); ok {
+			if decoded, ok := decodeExtValue(strings.TrimSpace(value)); ok {
+				ext[base] = decoded
+			}
+			continue
+		}
+		value, err := unquoteLenient(strings.TrimSpace(value))
+		if err != nil {
+			return Disposition{}, err
+		}
+		d.Params[name] = value
+	}
+	maps.Copy(d.Params, ext)
+	return d, nil
+}
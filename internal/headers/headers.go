@@ -1,18 +1,120 @@
 package headers
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"strings"
 	"unicode"
+
+	"github.com/dragonicorn/httpfromtcp/internal/logging"
 )
 
-type Headers map[string]string
+// Field is a single header field line.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Headers holds header fields in the order they were added, keeping the case
+// of their names. A name may occur several times; lookups ignore case. The
+// zero value is an empty Headers ready to use.
+type Headers []Field
+
+func NewHeaders() Headers {
+	return Headers{}
+}
+
+var (
+	ErrMissingHeaders  = errors.New("missing headers in request")
+	ErrMalformedHeader = errors.New("malformed header")
+)
+
+var logger = logging.Discard()
+
+// SetLogger sets the logger receiving header parser traces at debug level. A
+// nil logger silences the package.
+func SetLogger(l *slog.Logger) {
+	logger = logging.OrDiscard(l)
+}
 
+// Get returns the values of the fields named key combined into one, separated
+// by ", ", or "" if there are none. Use Values for fields such as Set-Cookie
+// that cannot be combined.
 func (h Headers) Get(key string) string {
-	if value, ok := h[strings.ToLower(key)]; ok {
-		return value
+	return strings.Join(h.Values(key), ", ")
+}
+
+// Values returns the values of the fields named key in order.
+func (h Headers) Values(key string) []string {
+	var values []string
+	for _, f := range h {
+		if strings.EqualFold(f.Name, key) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// Has reports whether a field named key is present.
+func (h Headers) Has(key string) bool {
+	return slices.ContainsFunc(h, func(f Field) bool { return strings.EqualFold(f.Name, key) })
+}
+
+// HasToken reports whether token is an element of the comma-separated list
+// held by the fields named key, as in "Connection: keep-alive, Upgrade".
+// Tokens are compared without regard to case and parameters are ignored.
+func (h Headers) HasToken(key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, elem := range strings.Split(v, ",") {
+			elem, _, _ = strings.Cut(elem, ";")
+			if strings.EqualFold(strings.TrimSpace(elem), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Add appends a field, keeping any others of the same name.
+func (h *Headers) Add(key, value string) {
+	*h = append(*h, Field{Name: key, Value: value})
+}
+
+// Set replaces the fields named key by a single field with value. It takes the
+// place of the first of them, or is appended if there is none.
+func (h *Headers) Set(key, value string) {
+	var (
+		fields Headers = (*h)[:0]
+		set    bool
+	)
+	for _, f := range *h {
+		if strings.EqualFold(f.Name, key) {
+			if set {
+				continue
+			}
+			f, set = Field{Name: key, Value: value}, true
+		}
+		fields = append(fields, f)
 	}
-	return ""
+	if !set {
+		fields = append(fields, Field{Name: key, Value: value})
+	}
+	*h = fields
+}
+
+// Del removes all fields named key.
+func (h *Headers) Del(key string) {
+	*h = slices.DeleteFunc(*h, func(f Field) bool { return strings.EqualFold(f.Name, key) })
+}
+
+// Clone returns a copy of h that can be modified independently.
+func (h Headers) Clone() Headers {
+	if h == nil {
+		return nil
+	}
+	return slices.Clone(h)
 }
 
 // 0-9, A-Z, a-z, !, #, $, %, &, ', *, +, -, ., ^, _, `, |, ~
@@ -41,7 +143,15 @@ func ValidateString(s string) bool {
 	return true
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// Parse parses one field line from data and appends it to h. done is returned
+// at the blank line ending the header section.
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWith(logger, data)
+}
+
+// ParseWith is like Parse but sends its traces to log instead of the package
+// logger, so that they can carry fields such as the connection ID.
+func (h *Headers) ParseWith(log *slog.Logger, data []byte) (n int, done bool, err error) {
 	var (
 		colon bool
 		crlf  bool
@@ -50,56 +160,46 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		test  string
 		value string
 	)
-	// fmt.Printf("Data to parse: \"%s\"\n", string(data))
 	line, _, crlf = strings.Cut(string(data), "\r\n")
 	// return zero bytes consumed if no end-of-line in message
 	if !crlf {
 		return 0, false, nil
 	}
 	n = len(line) + 2
-	// fmt.Printf("line: \"%s\" (%d)\n", line, n)
 	// return end of headers if line starts with CRLF
 	if n == 2 {
-		if len(h) == 0 {
-			return n, true, fmt.Errorf("missing headers in request")
+		if len(*h) == 0 {
+			return n, true, ErrMissingHeaders
 		}
-		fmt.Printf("\t    Data consumed: \"<CR><LF>\" (%d bytes)\n\tHeaders: %v\n", n, h)
+		log.Debug("end of headers", "bytes", n, "count", len(*h))
 		return n, true, nil
 	}
 	// return number of bytes consumed - including CRLF
 	key, value, colon = strings.Cut(line, ":")
 	// return error if no colon separator in header
 	if !colon {
-		return 0, false, fmt.Errorf("malformed header - %s", line)
+		return 0, false, fmt.Errorf("%w - %s", ErrMalformedHeader, line)
 	}
 	// check for illegal whitespace between field-name and ':'
 	test = strings.TrimSpace(key)
-	// fmt.Printf("key: \"%s\" test: \"%s\"\n", key, test)
 	if len(test) == 0 {
-		return 0, false, fmt.Errorf("malformed header (missing field-name) - %s", line)
+		return 0, false, fmt.Errorf("%w (missing field-name) - %s", ErrMalformedHeader, line)
 	}
 	if key[len(key)-1] != test[len(test)-1] {
-		return 0, false, fmt.Errorf("malformed header (illegal whitespace after field-name) - %s", line)
+		return 0, false, fmt.Errorf("%w (illegal whitespace after field-name) - %s", ErrMalformedHeader, line)
 	}
-	// fmt.Printf("key: \"%s\" value: \"%s\"\n", key, value)
-	key = strings.ToLower(strings.TrimSpace(key))
+	key = strings.TrimSpace(key)
 	// check for illegal character in field-name
 	if !ValidateString(key) {
-		return 0, false, fmt.Errorf("malformed header (illegal characters in field-name) - %s", line)
+		return 0, false, fmt.Errorf("%w (illegal characters in field-name) - %s", ErrMalformedHeader, line)
 	}
 	value = strings.TrimSpace(value)
 	// check for missing value
 	if len(value) == 0 {
-		return 0, false, fmt.Errorf("malformed header (missing field-value) - %s", line)
+		return 0, false, fmt.Errorf("%w (missing field-value) - %s", ErrMalformedHeader, line)
 	}
-	// fmt.Printf("key: \"%s\" value: \"%s\"\n", key, value)
 
-	// append value if key already exists
-	if test, ok := h[key]; ok {
-		value = fmt.Sprintf("%s, %s", test, value)
-	}
-	// fill header map with valid data
-	h[key] = value
-	fmt.Printf("\t    Data consumed: \"%s<CR><LF>\" (%d bytes)\n\t    Headers: %v\n", line, n, h)
+	h.Add(key, value)
+	log.Debug("header parsed", "bytes", n, "key", key, "value", value)
 	return n, false, nil
 }
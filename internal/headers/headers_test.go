@@ -18,28 +18,27 @@ func TestParse(t *testing.T) {
 		err     error
 	)
 	// Test: Valid single header
-	// headers := NewHeaders()
-	headers = make(Headers)
+	headers = NewHeaders()
 	data := []byte("Host: localhost:42069\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
 	// Test: Valid single header with extra whitespace
-	clear(headers)
+	headers = NewHeaders()
 	data = []byte("       HOST: localhost:42069       \r\n\r\n")
 	n, done, err = headers.Parse(data)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 37, n)
 	assert.False(t, done)
 
 	// Test: Valid 2 headers with existing headers
 	data = []byte("User-Agent:                 Mozilla/5.0 (X11; Linux x86_64; rv:12.0) Gecko/20100101 Firefox/12.0\r\nMax-Forwards: 10    \r\n")
 	n, done, err = headers.Parse(data)
-	assert.Equal(t, "Mozilla/5.0 (X11; Linux x86_64; rv:12.0) Gecko/20100101 Firefox/12.0", headers["user-agent"])
+	assert.Equal(t, "Mozilla/5.0 (X11; Linux x86_64; rv:12.0) Gecko/20100101 Firefox/12.0", headers.Get("user-agent"))
 	require.NoError(t, err)
 	assert.Equal(t, 98, n)
 	assert.False(t, done)
@@ -52,7 +51,7 @@ func TestParse(t *testing.T) {
 	assert.True(t, done)
 
 	// Test: Invalid done with no headers
-	clear(headers)
+	headers = NewHeaders()
 	data = []byte("\r\n")
 	n, done, err = headers.Parse(data)
 	require.Error(t, err)
@@ -60,7 +59,7 @@ func TestParse(t *testing.T) {
 	assert.True(t, done)
 
 	// Test: Invalid spacing header
-	clear(headers)
+	headers = NewHeaders()
 	data = []byte("       Host : localhost:42069       \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.Error(t, err)
@@ -68,7 +67,7 @@ func TestParse(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Invalid character in header
-	clear(headers)
+	headers = NewHeaders()
 	data = []byte("       H@st: localhost:42069       \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.Error(t, err)
@@ -76,7 +75,7 @@ func TestParse(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Missing field name in header
-	clear(headers)
+	headers = NewHeaders()
 	data = []byte("        : localhost:42069       \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.Error(t, err)
@@ -84,7 +83,7 @@ func TestParse(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Invalid field-value header
-	clear(headers)
+	headers = NewHeaders()
 	data = []byte("       Host :        \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.Error(t, err)
@@ -92,17 +91,100 @@ func TestParse(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Valid single header
-	clear(headers)
+	headers = NewHeaders()
 	data = []byte("Set-Person: lane-loves-go;\r\n\r\n")
 	n, done, err = headers.Parse(data)
-	assert.Equal(t, "lane-loves-go;", headers["set-person"])
+	assert.Equal(t, "lane-loves-go;", headers.Get("set-person"))
 	assert.Equal(t, 28, n)
 	assert.False(t, done)
 
 	// Test: Valid single header
 	data = []byte("Set-Person: prime-loves-zig;\r\n\r\n")
 	n, done, err = headers.Parse(data)
-	assert.Equal(t, "lane-loves-go;, prime-loves-zig;", headers["set-person"])
+	assert.Equal(t, "lane-loves-go;, prime-loves-zig;", headers.Get("set-person"))
 	assert.Equal(t, 30, n)
 	assert.False(t, done)
 }
+
+func TestHeaders(t *testing.T) {
+	var (
+		h   Headers
+		n   int
+		err error
+	)
+	// Test: Parsed fields keep their order and case
+	for _, line := range []string{"Host: localhost:42069\r\n", "Set-Cookie: a=1\r\n", "X-Custom: yes\r\n", "set-cookie: b=2\r\n"} {
+		n, _, err = h.Parse([]byte(line))
+		require.NoError(t, err)
+		assert.Equal(t, len(line), n)
+	}
+	assert.Equal(t, Headers{
+		{"Host", "localhost:42069"},
+		{"Set-Cookie", "a=1"},
+		{"X-Custom", "yes"},
+		{"set-cookie", "b=2"},
+	}, h)
+
+	// Test: Lookups ignore case and keep every value
+	assert.Equal(t, []string{"a=1", "b=2"}, h.Values("SET-COOKIE"))
+	assert.Equal(t, "a=1, b=2", h.Get("Set-Cookie"))
+	assert.True(t, h.Has("x-custom"))
+	assert.False(t, h.Has("X-Missing"))
+	assert.Equal(t, "", h.Get("X-Missing"))
+	assert.Nil(t, h.Values("X-Missing"))
+
+	// Test: Clone is independent of the original
+	c := h.Clone()
+	c.Set("Host", "example.com")
+	assert.Equal(t, "localhost:42069", h.Get("Host"))
+	assert.Equal(t, "example.com", c.Get("Host"))
+
+	// Test: Set replaces all values in place of the first
+	h.Set("SET-COOKIE", "c=3")
+	assert.Equal(t, Headers{
+		{"Host", "localhost:42069"},
+		{"SET-COOKIE", "c=3"},
+		{"X-Custom", "yes"},
+	}, h)
+	h.Set("X-New", "1")
+	assert.Equal(t, Field{"X-New", "1"}, h[len(h)-1])
+
+	// Test: Add keeps existing values
+	h.Add("x-custom", "no")
+	assert.Equal(t, []string{"yes", "no"}, h.Values("X-Custom"))
+
+	// Test: Del removes all values
+	h.Del("X-CUSTOM")
+	assert.False(t, h.Has("X-Custom"))
+	assert.Equal(t, 3, len(h))
+
+	// Test: The zero value is usable
+	var z Headers
+	z.Del("Host")
+	z.Set("Host", "localhost")
+	assert.Equal(t, "localhost", z.Get("host"))
+	assert.Nil(t, Headers(nil).Clone())
+}
+
+func TestHasToken(t *testing.T) {
+	h := Headers{
+		{"Connection", "keep-alive, Upgrade"},
+		{"transfer-encoding", "gzip"},
+		{"TRANSFER-ENCODING", "Chunked"},
+		{"Accept-Encoding", "gzip;q=1.0, br"},
+	}
+	assert.True(t, h.HasToken("connection", "upgrade"))
+	assert.True(t, h.HasToken("CONNECTION", "Keep-Alive"))
+	assert.False(t, h.HasToken("Connection", "close"))
+	assert.True(t, h.HasToken("Transfer-Encoding", "chunked"))
+	assert.True(t, h.HasToken("accept-encoding", "GZIP"))
+	assert.False(t, h.HasToken("Accept-Encoding", "q=1.0"))
+	assert.False(t, h.HasToken("Upgrade", "websocket"))
+
+	// Test: Names set in any case replace the same field
+	h.Set("connection", "close")
+	assert.Equal(t, Field{"connection", "close"}, h[0])
+	assert.True(t, h.HasToken("Connection", "close"))
+	h.Set("Transfer-Encoding", "chunked")
+	assert.Equal(t, []string{"chunked"}, h.Values("transfer-encoding"))
+}
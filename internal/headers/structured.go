@@ -0,0 +1,662 @@
+package headers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Structured Field Values, RFC 8941. A bare item is held as one of
+//
+//	int64    Integer
+//	float64  Decimal
+//	string   String
+//	Token    Token
+//	[]byte   Byte Sequence
+//	bool     Boolean
+//
+// Serialization also accepts an int for an Integer.
+
+// Token is a bare item of the Token type, as opposed to a String.
+type Token string
+
+// Param is a parameter of an Item or Inner List. A parameter without a value
+// has the value true.
+type Param struct {
+	Name  string
+	Value any
+}
+
+// Params are the parameters of an Item or Inner List, in order.
+type Params []Param
+
+// Get returns the value of the parameter name.
+func (p Params) Get(name string) (any, bool) {
+	for _, param := range p {
+		if param.Name == name {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Member is a member of a List or Dictionary: an Item or an InnerList.
+type Member interface {
+	member()
+}
+
+// Item is a bare item with parameters.
+type Item struct {
+	Value  any
+	Params Params
+}
+
+// InnerList is a parenthesized list of Items with parameters.
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+func (Item) member()      {}
+func (InnerList) member() {}
+
+// List is a List structured field.
+type List []Member
+
+// DictMember is a named member of a Dictionary.
+type DictMember struct {
+	Name  string
+	Value Member
+}
+
+// Dictionary is a Dictionary structured field, its members in order.
+type Dictionary []DictMember
+
+// Get returns the member name.
+func (d Dictionary) Get(name string) (Member, bool) {
+	for _, m := range d {
+		if m.Name == name {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// sfParser holds the input of a structured field and the parsing position.
+type sfParser struct {
+	s   string
+	pos int
+}
+
+func (p *sfParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w (structured field: %s at offset %d) - %s", ErrInvalidValue, fmt.Sprintf(format, args...), p.pos, p.s)
+}
+
+func (p *sfParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *sfParser) skipSP() {
+	for !p.eof() && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.eof() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// end checks that only spaces remain after the field.
+func (p *sfParser) end() error {
+	p.skipSP()
+	if !p.eof() {
+		return p.errorf("unexpected %q", p.peek())
+	}
+	return nil
+}
+
+// ParseItem parses an Item structured field.
+func ParseItem(s string) (Item, error) {
+	var (
+		err  error
+		item Item
+		p    = &sfParser{s: s}
+	)
+	p.skipSP()
+	item, err = p.item()
+	if err == nil {
+		err = p.end()
+	}
+	if err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// ParseList parses a List structured field. An empty field is an empty List.
+func ParseList(s string) (List, error) {
+	var (
+		err    error
+		l      = List{}
+		member Member
+		p      = &sfParser{s: s}
+	)
+	p.skipSP()
+	for !p.eof() {
+		member, err = p.member()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, member)
+		if err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// ParseDictionary parses a Dictionary structured field. An empty field is an
+// empty Dictionary. A repeated name replaces the earlier value in place.
+func ParseDictionary(s string) (Dictionary, error) {
+	var (
+		d      = Dictionary{}
+		err    error
+		member Member
+		name   string
+		params Params
+		p      = &sfParser{s: s}
+	)
+	p.skipSP()
+	for !p.eof() {
+		name, err = p.key()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() == '=' {
+			p.pos++
+			member, err = p.member()
+		} else {
+			params, err = p.params()
+			member = Item{Value: true, Params: params}
+		}
+		if err != nil {
+			return nil, err
+		}
+		d = setMember(d, name, member)
+		if err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func setMember(d Dictionary, name string, member Member) Dictionary {
+	for i := range d {
+		if d[i].Name == name {
+			d[i].Value = member
+			return d
+		}
+	}
+	return append(d, DictMember{name, member})
+}
+
+// next moves past the comma separating two members of a List or Dictionary.
+func (p *sfParser) next() error {
+	p.skipOWS()
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != ',' {
+		return p.errorf("expected ',' but found %q", p.peek())
+	}
+	p.pos++
+	p.skipOWS()
+	if p.eof() {
+		return p.errorf("trailing comma")
+	}
+	return nil
+}
+
+func (p *sfParser) member() (Member, error) {
+	if p.peek() == '(' {
+		return p.innerList()
+	}
+	return p.item()
+}
+
+func (p *sfParser) innerList() (InnerList, error) {
+	var (
+		err  error
+		il   = InnerList{Items: []Item{}}
+		item Item
+	)
+	p.pos++ // (
+	for !p.eof() {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.pos++
+			il.Params, err = p.params()
+			return il, err
+		}
+		item, err = p.item()
+		if err != nil {
+			return il, err
+		}
+		il.Items = append(il.Items, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return il, p.errorf("expected ' ' or ')' in inner list")
+		}
+	}
+	return il, p.errorf("unterminated inner list")
+}
+
+func (p *sfParser) item() (Item, error) {
+	var (
+		err  error
+		item Item
+	)
+	item.Value, err = p.bareItem()
+	if err != nil {
+		return item, err
+	}
+	item.Params, err = p.params()
+	return item, err
+}
+
+func (p *sfParser) params() (Params, error) {
+	var (
+		err    error
+		name   string
+		params = Params{}
+		value  any
+	)
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSP()
+		name, err = p.key()
+		if err != nil {
+			return nil, err
+		}
+		value = true
+		if p.peek() == '=' {
+			p.pos++
+			value, err = p.bareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		params = setParam(params, name, value)
+	}
+	return params, nil
+}
+
+func setParam(params Params, name string, value any) Params {
+	for i := range params {
+		if params[i].Name == name {
+			params[i].Value = value
+			return params
+		}
+	}
+	return append(params, Param{name, value})
+}
+
+func isLCAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isAlpha(c byte) bool {
+	return isLCAlpha(c) || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || strings.IndexByte("_-.*", c) >= 0
+}
+
+func isTokenChar(c byte) bool {
+	return c == ':' || c == '/' || c < 0x80 && ValidateString(string(rune(c)))
+}
+
+// validKey reports whether s may be a Dictionary or parameter name.
+func validKey(s string) bool {
+	if s == "" || !isLCAlpha(s[0]) && s[0] != '*' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isKeyChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *sfParser) key() (string, error) {
+	start := p.pos
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", p.errorf("invalid key")
+	}
+	for !p.eof() && isKeyChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *sfParser) bareItem() (any, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	case c == '*' || isAlpha(c):
+		return p.token(), nil
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		return p.boolean()
+	}
+	return nil, p.errorf("invalid bare item")
+}
+
+func (p *sfParser) number() (any, error) {
+	var (
+		decimal bool
+		digits  int // length of the number without its sign
+		start   = p.pos
+	)
+	if p.peek() == '-' {
+		p.pos++
+	}
+	if !isDigit(p.peek()) {
+		return nil, p.errorf("expected digit")
+	}
+	for ; !p.eof(); p.pos++ {
+		c := p.s[p.pos]
+		if c == '.' && !decimal {
+			if digits > 12 {
+				return nil, p.errorf("decimal integer part too long")
+			}
+			decimal = true
+		} else if !isDigit(c) {
+			break
+		}
+		digits++
+		if !decimal && digits > 15 || decimal && digits > 16 {
+			return nil, p.errorf("number too long")
+		}
+	}
+	num := p.s[start:p.pos]
+	if !decimal {
+		n, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer")
+		}
+		return n, nil
+	}
+	_, frac, _ := strings.Cut(num, ".")
+	if frac == "" || len(frac) > 3 {
+		return nil, p.errorf("decimal needs 1 to 3 fractional digits")
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return nil, p.errorf("invalid decimal")
+	}
+	return f, nil
+}
+
+func (p *sfParser) string() (string, error) {
+	var b strings.Builder
+	p.pos++ // "
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.eof() || p.s[p.pos] != '"' && p.s[p.pos] != '\\' {
+				return "", p.errorf("invalid escape in string")
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *sfParser) token() Token {
+	start := p.pos
+	p.pos++
+	for !p.eof() && isTokenChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return Token(p.s[start:p.pos])
+}
+
+func (p *sfParser) byteSequence() ([]byte, error) {
+	var (
+		b   []byte
+		err error
+		end int
+	)
+	p.pos++ // :
+	end = strings.IndexByte(p.s[p.pos:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	enc := p.s[p.pos : p.pos+end]
+	if strings.Trim(enc, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=") != "" {
+		return nil, p.errorf("invalid character in byte sequence")
+	}
+	// padding may be left out
+	b, err = base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		b, err = base64.RawStdEncoding.DecodeString(enc)
+	}
+	if err != nil {
+		return nil, p.errorf("invalid base64 in byte sequence")
+	}
+	p.pos += end + 1
+	return b, nil
+}
+
+func (p *sfParser) boolean() (bool, error) {
+	p.pos++ // ?
+	switch p.peek() {
+	case '1':
+		p.pos++
+		return true, nil
+	case '0':
+		p.pos++
+		return false, nil
+	}
+	return false, p.errorf("invalid boolean")
+}
+
+// Serialize returns the field value of the Item.
+func (i Item) Serialize() (string, error) {
+	var b strings.Builder
+	err := writeItem(&b, i)
+	return b.String(), err
+}
+
+// Serialize returns the field value of the List, empty for an empty List.
+func (l List) Serialize() (string, error) {
+	var (
+		b   strings.Builder
+		err error
+	)
+	for i, member := range l {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err = writeMember(&b, member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// Serialize returns the field value of the Dictionary, empty for an empty
+// Dictionary.
+func (d Dictionary) Serialize() (string, error) {
+	var (
+		b   strings.Builder
+		err error
+	)
+	for i, m := range d {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if !validKey(m.Name) {
+			return "", fmt.Errorf("%w (structured field key) - %s", ErrInvalidValue, m.Name)
+		}
+		b.WriteString(m.Name)
+		if item, ok := m.Value.(Item); ok && item.Value == true {
+			err = writeParams(&b, item.Params)
+		} else {
+			b.WriteByte('=')
+			err = writeMember(&b, m.Value)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func writeMember(b *strings.Builder, m Member) error {
+	switch m := m.(type) {
+	case Item:
+		return writeItem(b, m)
+	case InnerList:
+		b.WriteByte('(')
+		for i, item := range m.Items {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			if err := writeItem(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+		return writeParams(b, m.Params)
+	}
+	return fmt.Errorf("%w (structured field member) - %T", ErrInvalidValue, m)
+}
+
+func writeItem(b *strings.Builder, i Item) error {
+	err := writeBareItem(b, i.Value)
+	if err != nil {
+		return err
+	}
+	return writeParams(b, i.Params)
+}
+
+func writeParams(b *strings.Builder, params Params) error {
+	for _, param := range params {
+		if !validKey(param.Name) {
+			return fmt.Errorf("%w (structured field key) - %s", ErrInvalidValue, param.Name)
+		}
+		b.WriteByte(';')
+		b.WriteString(param.Name)
+		if param.Value == true {
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeBareItem(b, param.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBareItem(b *strings.Builder, v any) error {
+	switch v := v.(type) {
+	case int:
+		return writeBareItem(b, int64(v))
+	case int64:
+		if v > 999_999_999_999_999 || v < -999_999_999_999_999 {
+			return fmt.Errorf("%w (structured field integer out of range) - %d", ErrInvalidValue, v)
+		}
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		r := math.RoundToEven(v*1000) / 1000
+		if math.IsNaN(r) || math.Abs(r) >= 1e12 {
+			return fmt.Errorf("%w (structured field decimal out of range) - %v", ErrInvalidValue, v)
+		}
+		s := strings.TrimRight(strconv.FormatFloat(r, 'f', 3, 64), "0")
+		if strings.HasSuffix(s, ".") {
+			s += "0"
+		}
+		b.WriteString(s)
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			if v[i] < 0x20 || v[i] > 0x7e {
+				return fmt.Errorf("%w (structured field string) - %q", ErrInvalidValue, v)
+			}
+			if v[i] == '"' || v[i] == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(v[i])
+		}
+		b.WriteByte('"')
+	case Token:
+		if v == "" || v[0] != '*' && !isAlpha(v[0]) {
+			return fmt.Errorf("%w (structured field token) - %s", ErrInvalidValue, v)
+		}
+		for i := 1; i < len(v); i++ {
+			if !isTokenChar(v[i]) {
+				return fmt.Errorf("%w (structured field token) - %s", ErrInvalidValue, v)
+			}
+		}
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	default:
+		return fmt.Errorf("%w (structured field bare item type) - %T", ErrInvalidValue, v)
+	}
+	return nil
+}
+
+// StructuredItem parses the field named key as an Item.
+func (h Headers) StructuredItem(key string) (Item, error) {
+	return ParseItem(h.Get(key))
+}
+
+// StructuredList parses the fields named key as a List.
+func (h Headers) StructuredList(key string) (List, error) {
+	return ParseList(h.Get(key))
+}
+
+// StructuredDictionary parses the fields named key as a Dictionary.
+func (h Headers) StructuredDictionary(key string) (Dictionary, error) {
+	return ParseDictionary(h.Get(key))
+}
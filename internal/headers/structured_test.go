@@ -0,0 +1,272 @@
+package headers
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sfTest is a test case in the format of the RFC 8941 test suite published at
+// https://github.com/httpwg/structured-field-tests.
+type sfTest struct {
+	Name       string          `json:"name"`
+	Raw        []string        `json:"raw"`
+	HeaderType string          `json:"header_type"`
+	Expected   json.RawMessage `json:"expected"`
+	MustFail   bool            `json:"must_fail"`
+	CanFail    bool            `json:"can_fail"`
+	Canonical  []string        `json:"canonical"`
+}
+
+// sfBareItem converts the JSON form of a bare item used by the test suite.
+func sfBareItem(t *testing.T, v any) any {
+	t.Helper()
+	switch v := v.(type) {
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			f, err := v.Float64()
+			require.NoError(t, err)
+			return f
+		}
+		n, err := v.Int64()
+		require.NoError(t, err)
+		return n
+	case map[string]any:
+		switch v["__type"] {
+		case "token":
+			return Token(v["value"].(string))
+		case "binary":
+			b, err := base32.StdEncoding.DecodeString(v["value"].(string))
+			require.NoError(t, err)
+			return b
+		}
+	}
+	return v
+}
+
+func sfParams(t *testing.T, v any) Params {
+	params := Params{}
+	for _, p := range v.([]any) {
+		p := p.([]any)
+		params = append(params, Param{p[0].(string), sfBareItem(t, p[1])})
+	}
+	return params
+}
+
+// sfMember converts an item, [bare, params], or an inner list, [[items], params].
+func sfMember(t *testing.T, v any) Member {
+	m := v.([]any)
+	if items, ok := m[0].([]any); ok {
+		il := InnerList{Items: []Item{}, Params: sfParams(t, m[1])}
+		for _, item := range items {
+			il.Items = append(il.Items, sfMember(t, item).(Item))
+		}
+		return il
+	}
+	return Item{sfBareItem(t, m[0]), sfParams(t, m[1])}
+}
+
+func sfExpected(t *testing.T, headerType string, raw json.RawMessage) any {
+	var v any
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&v))
+	switch headerType {
+	case "item":
+		return sfMember(t, v)
+	case "list":
+		l := List{}
+		for _, m := range v.([]any) {
+			l = append(l, sfMember(t, m))
+		}
+		return l
+	default:
+		d := Dictionary{}
+		for _, m := range v.([]any) {
+			m := m.([]any)
+			d = append(d, DictMember{m[0].(string), sfMember(t, m[1])})
+		}
+		return d
+	}
+}
+
+// sfTests reads the test cases of the suite files matching pattern.
+func sfTests(t *testing.T, pattern string) map[string][]sfTest {
+	t.Helper()
+	files, err := filepath.Glob(pattern)
+	require.NoError(t, err)
+	suite := make(map[string][]sfTest)
+	for _, file := range files {
+		var tests []sfTest
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &tests), file)
+		suite[file] = tests
+	}
+	return suite
+}
+
+func TestStructuredFieldSuite(t *testing.T) {
+	suite := sfTests(t, "testdata/structured/*.json")
+	require.NotEmpty(t, suite)
+	for file, tests := range suite {
+		for _, tt := range tests {
+			t.Run(filepath.Base(file)+"/"+tt.Name, func(t *testing.T) {
+				var (
+					err        error
+					got        any
+					raw        = strings.Join(tt.Raw, ", ")
+					serialized string
+				)
+				switch tt.HeaderType {
+				case "item":
+					var item Item
+					item, err = ParseItem(raw)
+					if err == nil {
+						got = item
+						serialized, err = item.Serialize()
+					}
+				case "list":
+					var l List
+					l, err = ParseList(raw)
+					if err == nil {
+						got = l
+						serialized, err = l.Serialize()
+					}
+				case "dictionary":
+					var d Dictionary
+					d, err = ParseDictionary(raw)
+					if err == nil {
+						got = d
+						serialized, err = d.Serialize()
+					}
+				}
+				if tt.MustFail {
+					assert.ErrorIs(t, err, ErrInvalidValue, raw)
+					return
+				}
+				if tt.CanFail && err != nil {
+					return
+				}
+				require.NoError(t, err, raw)
+				assert.Equal(t, sfExpected(t, tt.HeaderType, tt.Expected), got, raw)
+				canonical := raw
+				if tt.Canonical != nil {
+					canonical = strings.Join(tt.Canonical, ", ")
+				}
+				assert.Equal(t, canonical, serialized)
+			})
+		}
+	}
+}
+
+func TestStructuredSerialize(t *testing.T) {
+	var (
+		err error
+		s   string
+	)
+	// Test: Decimals are rounded to three fractional digits, half to even
+	for _, tt := range []struct {
+		f    float64
+		want string
+	}{
+		{1, "1.0"},
+		{0.0005, "0.0"},
+		{0.0015, "0.002"},
+		{-3.14159, "-3.142"},
+		{123456789012.5, "123456789012.5"},
+	} {
+		s, err = Item{Value: tt.f}.Serialize()
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, s)
+	}
+
+	// Test: All bare item types
+	s, err = List{
+		Item{Value: 7, Params: Params{{"a", true}, {"b", false}}},
+		InnerList{Items: []Item{{Value: Token("*foo")}, {Value: []byte("hi")}}, Params: Params{{"q", "x\"y"}}},
+	}.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, `7;a;b=?0, (*foo :aGk=:);q="x\"y"`, s)
+
+	// Test: Values outside the RFC 8941 ranges cannot be serialized
+	for _, v := range []any{
+		int64(1_000_000_000_000_000),
+		1e12,
+		"tab\t",
+		Token("1abc"),
+		Token("a b"),
+		uint8(1),
+		nil,
+	} {
+		_, err = Item{Value: v}.Serialize()
+		assert.ErrorIs(t, err, ErrInvalidValue, v)
+	}
+	_, err = Dictionary{{Name: "Upper", Value: Item{Value: 1}}}.Serialize()
+	assert.ErrorIs(t, err, ErrInvalidValue)
+	_, err = Item{Value: 1, Params: Params{{"", 1}}}.Serialize()
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	// Test: Keys, numbers, strings and tokens at the edges of their ranges
+	for _, tt := range []struct {
+		m    Member
+		want string
+	}{
+		{Item{Value: int64(999_999_999_999_999)}, "999999999999999"},
+		{Item{Value: int64(-999_999_999_999_999)}, "-999999999999999"},
+		{Item{Value: 999_999_999_999.999}, "999999999999.999"},
+		{Item{Value: 0.0025}, "0.002"},
+		{Item{Value: 0.0035}, "0.004"},
+		{Item{Value: -0.0}, "0.0"},
+		{Item{Value: " ~!\\\""}, `" ~!\\\""`},
+		{Item{Value: Token("*a:/%")}, "*a:/%"},
+		{Item{Value: []byte{}}, "::"},
+		{Item{Value: 1, Params: Params{{"*a_b-c.9", true}, {"z", Token("x")}}}, "1;*a_b-c.9;z=x"},
+		{InnerList{Items: []Item{}, Params: Params{{"p", 1}}}, "();p=1"},
+	} {
+		s, err = List{tt.m}.Serialize()
+		require.NoError(t, err, tt.want)
+		assert.Equal(t, tt.want, s)
+	}
+	for _, m := range []Member{
+		Item{Value: int64(-1_000_000_000_000_000)},
+		Item{Value: 1_000_000_000_000.0},
+		Item{Value: -1_000_000_000_000.0},
+		Item{Value: "\x7f"},
+		Item{Value: "é"},
+		Item{Value: Token("")},
+		Item{Value: Token("a\"b")},
+		Item{Value: 1, Params: Params{{"1a", 1}}},
+		Item{Value: 1, Params: Params{{"a", "\n"}}},
+		InnerList{Items: []Item{{Value: "\t"}}},
+	} {
+		_, err = List{m}.Serialize()
+		assert.ErrorIs(t, err, ErrInvalidValue, m)
+	}
+	for _, name := range []string{"a A", "-a", "a\x00", ""} {
+		_, err = Dictionary{{Name: name, Value: Item{Value: 1}}}.Serialize()
+		assert.ErrorIs(t, err, ErrInvalidValue, name)
+	}
+
+	// Test: Fields are read from Headers, combining repeated ones
+	h := Headers{{"Priority", "u=1, i"}, {"Cache-Status", "ExampleCache; hit"}, {"Cache-Status", `other; fwd=uri-miss; key="/x"`}}
+	d, err := h.StructuredDictionary("priority")
+	require.NoError(t, err)
+	u, ok := d.Get("u")
+	require.True(t, ok)
+	assert.Equal(t, Item{Value: int64(1), Params: Params{}}, u)
+	l, err := h.StructuredList("Cache-Status")
+	require.NoError(t, err)
+	require.Len(t, l, 2)
+	fwd, ok := l[1].(Item).Params.Get("fwd")
+	require.True(t, ok)
+	assert.Equal(t, Token("uri-miss"), fwd)
+	_, err = h.StructuredItem("Missing")
+	assert.ErrorIs(t, err, ErrInvalidValue)
+}
@@ -0,0 +1,48 @@
+// Package logging holds the pieces shared by the packages that accept an
+// injectable *slog.Logger.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// Discard returns a logger that drops every record. It is the default for all
+// packages until a logger is injected.
+func Discard() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+// OrDiscard returns l, or a discarding logger if l is nil.
+func OrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return Discard()
+	}
+	return l
+}
+
+// FixCRLF makes carriage returns and line feeds in str visible.
+func FixCRLF(str string) string {
+	return strings.Replace(strings.Replace(str, "\r", "<CR>", -1), "\n", "<LF>", -1)
+}
+
+type crlf []byte
+
+func (c crlf) LogValue() slog.Value {
+	return slog.StringValue(FixCRLF(string(c)))
+}
+
+// CRLF returns an attribute holding data rendered by FixCRLF. The rendering is
+// deferred until a handler actually emits the record, so disabled debug traces
+// cost nothing.
+func CRLF(key string, data []byte) slog.Attr {
+	return slog.Any(key, crlf(data))
+}
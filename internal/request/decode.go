@@ -0,0 +1,85 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrMalformedEncoding   = errors.New("malformed encoded body")
+	ErrBodyTooLarge        = errors.New("decoded body exceeds size limit")
+)
+
+// SupportedEncodings are the content codings DecodeBody can remove.
+var SupportedEncodings = []string{"gzip", "deflate"}
+
+// decoder returns a reader decompressing data according to coding.
+func decoder(coding string, data []byte) (io.ReadCloser, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		// "deflate" is the zlib format, but some clients send raw deflate data
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err == zlib.ErrHeader {
+			return flate.NewReader(bytes.NewReader(data)), nil
+		}
+		return zr, err
+	}
+	return nil, fmt.Errorf("%w - %s", ErrUnsupportedEncoding, coding)
+}
+
+// DecodeBody removes the content codings listed by Content-Encoding from the
+// body, in the reverse order of their application. The decoded body may not
+// exceed maxSize bytes. On success Content-Encoding is removed and
+// Content-Length updated, while VerifyContentDigest keeps checking the body as
+// it was received. The body is left unchanged on error.
+func (req *Request) DecodeBody(maxSize int64) error {
+	var (
+		codings []string
+		data    []byte = req.Body
+		dec     io.ReadCloser
+		err     error
+	)
+	for _, coding := range req.Headers.List("Content-Encoding") {
+		coding = strings.ToLower(coding)
+		if coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	if len(codings) == 0 {
+		return nil
+	}
+	for _, coding := range slices.Backward(codings) {
+		dec, err = decoder(coding, data)
+		if errors.Is(err, ErrUnsupportedEncoding) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("%w (%s) - %v", ErrMalformedEncoding, coding, err)
+		}
+		// read one byte beyond the limit to detect bodies exceeding it
+		data, err = io.ReadAll(io.LimitReader(dec, maxSize+1))
+		dec.Close()
+		if err != nil {
+			return fmt.Errorf("%w (%s) - %v", ErrMalformedEncoding, coding, err)
+		}
+		if int64(len(data)) > maxSize {
+			return fmt.Errorf("%w - %d bytes", ErrBodyTooLarge, maxSize)
+		}
+	}
+	req.encodedBody = req.Body
+	req.Body = data
+	req.Headers.Del("Content-Encoding")
+	req.Headers.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
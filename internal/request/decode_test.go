@@ -0,0 +1,107 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dragonicorn/httpfromtcp/internal/digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func encodedRequest(t *testing.T, encoding string, body []byte, extra string) *Request {
+	t.Helper()
+	r, err := RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: " + encoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		extra +
+		"\r\n" +
+		string(body)))
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecodeBody(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		err   error
+		plain = []byte(strings.Repeat("hello world!\n", 100))
+		r     *Request
+	)
+	// Test: gzip
+	r = encodedRequest(t, "gzip", gzipped(t, plain), "")
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, plain, r.Body)
+	assert.Equal(t, "", r.Headers.Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(len(plain)), r.Headers.Get("Content-Length"))
+
+	// Test: deflate in zlib format
+	zw := zlib.NewWriter(&buf)
+	zw.Write(plain)
+	zw.Close()
+	r = encodedRequest(t, "deflate", buf.Bytes(), "")
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, plain, r.Body)
+
+	// Test: raw deflate
+	buf.Reset()
+	fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	fw.Write(plain)
+	fw.Close()
+	r = encodedRequest(t, "deflate", buf.Bytes(), "")
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, plain, r.Body)
+
+	// Test: Codings are removed in reverse order
+	r = encodedRequest(t, "gzip, identity, GZIP", gzipped(t, gzipped(t, plain)), "")
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, plain, r.Body)
+
+	// Test: Bodies without Content-Encoding are left alone
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody(1))
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Decompression stops at the size limit
+	r = encodedRequest(t, "gzip", gzipped(t, make([]byte, 10<<20)), "")
+	assert.ErrorIs(t, r.DecodeBody(1<<20), ErrBodyTooLarge)
+	assert.Equal(t, "gzip", r.Headers.Get("Content-Encoding"))
+	r = encodedRequest(t, "gzip", gzipped(t, plain), "")
+	require.NoError(t, r.DecodeBody(int64(len(plain))))
+
+	// Test: Unsupported encoding
+	r = encodedRequest(t, "br", plain, "")
+	assert.ErrorIs(t, r.DecodeBody(1<<20), ErrUnsupportedEncoding)
+	assert.Equal(t, plain, r.Body)
+
+	// Test: Corrupt data
+	r = encodedRequest(t, "gzip", plain, "")
+	assert.ErrorIs(t, r.DecodeBody(1<<20), ErrMalformedEncoding)
+	r = encodedRequest(t, "gzip", gzipped(t, plain)[:50], "")
+	assert.ErrorIs(t, r.DecodeBody(1<<20), ErrMalformedEncoding)
+
+	// Test: Content-Digest is verified against the body as received
+	body := gzipped(t, plain)
+	d, err := digest.Of(digest.SHA256, body)
+	require.NoError(t, err)
+	r = encodedRequest(t, "gzip", body, "Content-Digest: "+digest.Field(d)+"\r\n")
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.NoError(t, r.VerifyContentDigest())
+}
@@ -0,0 +1,174 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrInvalidForm     = errors.New("invalid form")
+	ErrFormTooLarge    = errors.New("form exceeds limits")
+	ErrUnsupportedForm = errors.New("unsupported form charset")
+)
+
+// FormLimits bound the forms ParseForm accepts.
+type FormLimits struct {
+	// MaxFields is the largest number of fields, query parameters included.
+	MaxFields int
+	// MaxSize is the largest url-encoded body, in bytes.
+	MaxSize int64
+}
+
+var DefaultFormLimits = FormLimits{MaxFields: 1000, MaxSize: 10 << 20}
+
+// Query parses the query parameters of the request target.
+func (req *Request) Query() (url.Values, error) {
+	_, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w (query) - %v", ErrInvalidForm, err)
+	}
+	return values, nil
+}
+
+// countFields returns the number of fields in a url-encoded string.
+func countFields(s string) int {
+	if s == "" {
+		return 0
+	}
+	return strings.Count(s, "&") + 1
+}
+
+// latin1ToUTF8 converts ISO-8859-1 text, whose bytes are the code points, to
+// UTF-8.
+func latin1ToUTF8(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(rune(s[i]))
+	}
+	return b.String()
+}
+
+// isASCII reports whether every byte of s is a US-ASCII character.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFormBody decodes an application/x-www-form-urlencoded body in the given
+// charset into values.
+func parseFormBody(body, charset string) (url.Values, error) {
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w (body) - %v", ErrInvalidForm, err)
+	}
+	switch charset {
+	case "", "utf-8":
+		for k, vs := range values {
+			if !utf8.ValidString(k) || !utf8.ValidString(strings.Join(vs, "")) {
+				return nil, fmt.Errorf("%w (body) - invalid UTF-8 in field %q", ErrInvalidForm, k)
+			}
+		}
+	case "us-ascii":
+		for k, vs := range values {
+			if !isASCII(k) || !isASCII(strings.Join(vs, "")) {
+				return nil, fmt.Errorf("%w (body) - non-ASCII byte in field %q", ErrInvalidForm, k)
+			}
+		}
+	case "iso-8859-1", "latin1":
+		converted := make(url.Values, len(values))
+		for k, vs := range values {
+			for _, v := range vs {
+				converted.Add(latin1ToUTF8(k), latin1ToUTF8(v))
+			}
+		}
+		values = converted
+	default:
+		return nil, fmt.Errorf("%w - %s", ErrUnsupportedForm, charset)
+	}
+	return values, nil
+}
+
+// ParseForm fills PostForm with the fields of an
+// application/x-www-form-urlencoded body of a POST, PUT or PATCH request, and
+// Form with those fields followed by the query parameters. Bodies in UTF-8,
+// US-ASCII and ISO-8859-1 are decoded according to the charset parameter of
+// Content-Type; other charsets are refused with ErrUnsupportedForm. Forms
+// beyond limits are refused with ErrFormTooLarge. Calling it again does
+// nothing.
+func (req *Request) ParseForm(limits FormLimits) error {
+	var (
+		err    error
+		fields int
+		mt     headers.MediaType
+		query  string
+		post   = make(url.Values)
+		values url.Values
+	)
+	if req.Form != nil {
+		return nil
+	}
+	_, query, _ = strings.Cut(req.RequestLine.RequestTarget, "?")
+	fields = countFields(query)
+	switch req.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+		mt, err = req.Headers.ContentType()
+		if err != nil || mt.MediaType() != "application/x-www-form-urlencoded" {
+			break
+		}
+		if int64(len(req.Body)) > limits.MaxSize {
+			return fmt.Errorf("%w - body of %d bytes exceeds %d", ErrFormTooLarge, len(req.Body), limits.MaxSize)
+		}
+		fields += countFields(string(req.Body))
+		if fields > limits.MaxFields {
+			return fmt.Errorf("%w - more than %d fields", ErrFormTooLarge, limits.MaxFields)
+		}
+		post, err = parseFormBody(string(req.Body), strings.ToLower(mt.Params["charset"]))
+		if err != nil {
+			return err
+		}
+	}
+	if fields > limits.MaxFields {
+		return fmt.Errorf("%w - more than %d fields", ErrFormTooLarge, limits.MaxFields)
+	}
+	values, err = req.Query()
+	if err != nil {
+		return err
+	}
+	req.PostForm = post
+	req.Form = make(url.Values, len(post)+len(values))
+	for k, vs := range post {
+		req.Form[k] = append(req.Form[k], vs...)
+	}
+	for k, vs := range values {
+		req.Form[k] = append(req.Form[k], vs...)
+	}
+	return nil
+}
+
+// FormValue returns the first value of the field key in the body or query,
+// parsing the form with DefaultFormLimits if needed. It returns "" if the
+// field is missing or the form cannot be parsed; call ParseForm to see why.
+func (req *Request) FormValue(key string) string {
+	if req.ParseForm(DefaultFormLimits) != nil {
+		return ""
+	}
+	return req.Form.Get(key)
+}
+
+// PostFormValue is like FormValue but ignores the query.
+func (req *Request) PostFormValue(key string) string {
+	if req.ParseForm(DefaultFormLimits) != nil {
+		return ""
+	}
+	return req.PostForm.Get(key)
+}
@@ -0,0 +1,82 @@
+package request
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formRequest(t *testing.T, method, target, contentType, body string) *Request {
+	t.Helper()
+	raw := method + " " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n"
+	if contentType != "" {
+		raw += "Content-Type: " + contentType + "\r\n"
+	}
+	raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+func TestParseForm(t *testing.T) {
+	var (
+		err error
+		r   *Request
+	)
+	// Test: Body fields precede query parameters
+	r = formRequest(t, "POST", "/submit?name=query&page=2", "application/x-www-form-urlencoded", "name=Jane+Doe&tag=a&tag=b%26c&empty=")
+	require.NoError(t, r.ParseForm(DefaultFormLimits))
+	assert.Equal(t, url.Values{"name": {"Jane Doe"}, "tag": {"a", "b&c"}, "empty": {""}}, r.PostForm)
+	assert.Equal(t, []string{"Jane Doe", "query"}, r.Form["name"])
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.Equal(t, "", r.PostFormValue("page"))
+	assert.Equal(t, "Jane Doe", r.PostFormValue("name"))
+
+	// Test: Only the query is parsed for GET and other content types
+	r = formRequest(t, "GET", "/search?q=gophers", "application/x-www-form-urlencoded", "q=body")
+	assert.Equal(t, "gophers", r.FormValue("q"))
+	assert.Empty(t, r.PostForm)
+	r = formRequest(t, "POST", "/search?q=gophers", "application/json", `{"q": "body"}`)
+	require.NoError(t, r.ParseForm(DefaultFormLimits))
+	assert.Equal(t, []string{"gophers"}, r.Form["q"])
+	r = formRequest(t, "PUT", "/search", "", "q=body")
+	assert.Equal(t, "", r.FormValue("q"))
+
+	// Test: Charsets
+	r = formRequest(t, "POST", "/", `Application/X-WWW-Form-Urlencoded; charset="UTF-8"`, "city=Z%C3%BCrich")
+	assert.Equal(t, "Zürich", r.FormValue("city"))
+	r = formRequest(t, "PATCH", "/", "application/x-www-form-urlencoded; charset=ISO-8859-1", "city=Z%FCrich")
+	assert.Equal(t, "Zürich", r.FormValue("city"))
+	r = formRequest(t, "POST", "/", "application/x-www-form-urlencoded", "city=Z%FCrich")
+	assert.ErrorIs(t, r.ParseForm(DefaultFormLimits), ErrInvalidForm)
+	r = formRequest(t, "POST", "/", "application/x-www-form-urlencoded; charset=us-ascii", "city=Zurich")
+	assert.Equal(t, "Zurich", r.FormValue("city"))
+	r = formRequest(t, "POST", "/", "application/x-www-form-urlencoded; charset=us-ascii", "city=Z%C3%BCrich")
+	assert.ErrorIs(t, r.ParseForm(DefaultFormLimits), ErrInvalidForm)
+	r = formRequest(t, "POST", "/", "application/x-www-form-urlencoded; charset=shift_jis", "a=1")
+	assert.ErrorIs(t, r.ParseForm(DefaultFormLimits), ErrUnsupportedForm)
+	assert.Equal(t, "", r.FormValue("a"))
+
+	// Test: Malformed forms
+	r = formRequest(t, "POST", "/", "application/x-www-form-urlencoded", "a=%zz")
+	assert.ErrorIs(t, r.ParseForm(DefaultFormLimits), ErrInvalidForm)
+	r = formRequest(t, "POST", "/?a=1;b=2", "application/x-www-form-urlencoded", "")
+	assert.ErrorIs(t, r.ParseForm(DefaultFormLimits), ErrInvalidForm)
+
+	// Test: Limits
+	limits := FormLimits{MaxFields: 3, MaxSize: 16}
+	r = formRequest(t, "POST", "/?c=3", "application/x-www-form-urlencoded", "a=1&b=2")
+	require.NoError(t, r.ParseForm(limits))
+	r = formRequest(t, "POST", "/?c=3&d=4", "application/x-www-form-urlencoded", "a=1&b=2")
+	assert.ErrorIs(t, r.ParseForm(limits), ErrFormTooLarge)
+	r = formRequest(t, "GET", "/?a=1&b=2&c=3&d=4", "", "")
+	assert.ErrorIs(t, r.ParseForm(limits), ErrFormTooLarge)
+	r = formRequest(t, "POST", "/", "application/x-www-form-urlencoded", "text="+strings.Repeat("x", 12))
+	err = r.ParseForm(limits)
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	assert.Nil(t, r.Form)
+}
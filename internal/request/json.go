@@ -0,0 +1,73 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotJSON      = errors.New("request content type is not JSON")
+	ErrInvalidJSON  = errors.New("invalid JSON body")
+	ErrJSONTooLarge = errors.New("JSON body exceeds size limit")
+)
+
+// JSONOptions control how DecodeJSON decodes a body.
+type JSONOptions struct {
+	// MaxSize is the largest body accepted, in bytes.
+	MaxSize int64
+	// AllowUnknownFields accepts object keys matching no field of the
+	// destination struct instead of rejecting the body.
+	AllowUnknownFields bool
+}
+
+var DefaultJSONOptions = JSONOptions{MaxSize: 1 << 20}
+
+// IsJSON reports whether the request body is declared as JSON, that is
+// application/json or a media type with the +json suffix, in UTF-8.
+func (req *Request) IsJSON() bool {
+	mt, err := req.Headers.ContentType()
+	if err != nil || mt.Type != "application" {
+		return false
+	}
+	if mt.Subtype != "json" && !strings.HasSuffix(mt.Subtype, "+json") {
+		return false
+	}
+	charset, ok := mt.Params["charset"]
+	return !ok || strings.EqualFold(charset, "utf-8")
+}
+
+// DecodeJSON decodes a body holding exactly one JSON value into v. It fails
+// with ErrNotJSON unless the body is declared as JSON, with ErrJSONTooLarge for
+// a body over opts.MaxSize, and with ErrInvalidJSON for malformed JSON, values
+// of the wrong type, trailing data and, unless allowed, unknown fields.
+func (req *Request) DecodeJSON(v any, opts JSONOptions) error {
+	var (
+		dec *json.Decoder
+		err error
+	)
+	if !req.IsJSON() {
+		return fmt.Errorf("%w - %s", ErrNotJSON, req.Headers.Get("Content-Type"))
+	}
+	if int64(len(req.Body)) > opts.MaxSize {
+		return fmt.Errorf("%w - body of %d bytes exceeds %d", ErrJSONTooLarge, len(req.Body), opts.MaxSize)
+	}
+	dec = json.NewDecoder(bytes.NewReader(req.Body))
+	if !opts.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	err = dec.Decode(v)
+	if err == io.EOF {
+		return fmt.Errorf("%w - empty body", ErrInvalidJSON)
+	}
+	if err != nil {
+		return fmt.Errorf("%w - %v", ErrInvalidJSON, err)
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return fmt.Errorf("%w - data after the JSON value", ErrInvalidJSON)
+	}
+	return nil
+}
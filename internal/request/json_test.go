@@ -0,0 +1,54 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jsonItem struct {
+	Name  string   `json:"name"`
+	Price float64  `json:"price"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var (
+		err  error
+		item jsonItem
+		r    *Request
+	)
+	// Test: Valid bodies with JSON media types
+	for _, ct := range []string{"application/json", "Application/JSON; charset=UTF-8", "application/merge-patch+json"} {
+		item = jsonItem{}
+		r = formRequest(t, "POST", "/items", ct, `{"name": "pen", "price": 1.5, "tags": ["office"]}`+"\n")
+		require.NoError(t, r.DecodeJSON(&item, DefaultJSONOptions), ct)
+		assert.Equal(t, jsonItem{Name: "pen", Price: 1.5, Tags: []string{"office"}}, item, ct)
+	}
+
+	// Test: Other media types and charsets
+	for _, ct := range []string{"", "text/plain", "application/x-www-form-urlencoded", "text/json", "application/json; charset=utf-16", "application/json+xml"} {
+		r = formRequest(t, "POST", "/items", ct, `{"name": "pen"}`)
+		assert.ErrorIs(t, r.DecodeJSON(&item, DefaultJSONOptions), ErrNotJSON, ct)
+	}
+
+	// Test: Unknown fields
+	r = formRequest(t, "POST", "/items", "application/json", `{"name": "pen", "colour": "blue"}`)
+	err = r.DecodeJSON(&item, DefaultJSONOptions)
+	assert.ErrorIs(t, err, ErrInvalidJSON)
+	assert.ErrorContains(t, err, "colour")
+	item = jsonItem{}
+	require.NoError(t, r.DecodeJSON(&item, JSONOptions{MaxSize: 1 << 10, AllowUnknownFields: true}))
+	assert.Equal(t, "pen", item.Name)
+
+	// Test: Invalid bodies
+	for _, body := range []string{"", "  ", `{"name": "pen"`, `{"name": 5}`, `{"name": "pen"} {"name": "ink"}`, `{"name": "pen"}]`, `[1, 2]`} {
+		r = formRequest(t, "POST", "/items", "application/json", body)
+		assert.ErrorIs(t, r.DecodeJSON(&item, DefaultJSONOptions), ErrInvalidJSON, body)
+	}
+
+	// Test: Size limit
+	r = formRequest(t, "POST", "/items", "application/json", `{"name": "fountain pen"}`)
+	assert.ErrorIs(t, r.DecodeJSON(&item, JSONOptions{MaxSize: 16}), ErrJSONTooLarge)
+}
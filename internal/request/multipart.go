@@ -0,0 +1,491 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrNotMultipart      = errors.New("request is not multipart/form-data")
+	ErrInvalidMultipart  = errors.New("invalid multipart body")
+	ErrPartTooLarge      = errors.New("multipart part exceeds size limit")
+	ErrMultipartTooLarge = errors.New("multipart body exceeds limits")
+)
+
+// size of the buffer in which MultipartReader looks for boundaries
+const multipartBufSize = 64 << 10
+
+// MultipartReader reads the parts of a multipart body one after the other,
+// without holding more than a buffer of it in memory.
+type MultipartReader struct {
+	// MaxHeaderSize limits the header section of each part.
+	MaxHeaderSize int
+
+	r        *bufio.Reader
+	boundary []byte // "--" boundary
+	delim    []byte // CRLF "--" boundary, ending the content of a part
+	current  *Part
+	started  bool
+	done     bool
+}
+
+// NewMultipartReader returns a MultipartReader for a body with the given
+// boundary.
+func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		MaxHeaderSize: 16 << 10,
+		r:             bufio.NewReaderSize(r, multipartBufSize),
+		boundary:      []byte("--" + boundary),
+		delim:         []byte("\r\n--" + boundary),
+	}
+}
+
+// MultipartReader returns a MultipartReader over the body of a
+// multipart/form-data request.
+func (req *Request) MultipartReader() (*MultipartReader, error) {
+	mt, err := req.Headers.ContentType()
+	if err != nil || mt.MediaType() != "multipart/form-data" {
+		return nil, fmt.Errorf("%w - %s", ErrNotMultipart, req.Headers.Get("Content-Type"))
+	}
+	boundary := mt.Params["boundary"]
+	if boundary == "" || len(boundary) > 70 || strings.HasSuffix(boundary, " ") {
+		return nil, fmt.Errorf("%w (boundary) - %q", ErrInvalidMultipart, boundary)
+	}
+	return NewMultipartReader(bytes.NewReader(req.Body), boundary), nil
+}
+
+// Part is a part of a multipart body. Read returns its content.
+type Part struct {
+	Headers headers.Headers
+
+	mr          *MultipartReader
+	disposition *headers.Disposition
+	eof         bool
+}
+
+func (p *Part) parseDisposition() *headers.Disposition {
+	if p.disposition == nil {
+		d, err := headers.ParseContentDisposition(p.Headers.Get("Content-Disposition"))
+		if err != nil {
+			d = headers.Disposition{}
+		}
+		p.disposition = &d
+	}
+	return p.disposition
+}
+
+// FormName returns the name parameter of a form-data Content-Disposition, or
+// "" for any other part.
+func (p *Part) FormName() string {
+	d := p.parseDisposition()
+	if d.Type != "form-data" {
+		return ""
+	}
+	return d.Params["name"]
+}
+
+// FileName returns the base name of the filename parameter of the
+// Content-Disposition, without any directory a client may have sent.
+func (p *Part) FileName() string {
+	name := p.parseDisposition().Params["filename"]
+	if name == "" {
+		return ""
+	}
+	// some clients send the whole path, with either separator
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "/" || name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// Read reads the content of the part, stopping at the next boundary.
+func (p *Part) Read(b []byte) (int, error) {
+	var (
+		buf   []byte
+		err   error
+		i     int
+		mr    = p.mr
+		n     int
+		delim = mr.delim
+	)
+	if p.eof {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	buf, err = mr.r.Peek(multipartBufSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return 0, err
+	}
+	for off := 0; ; {
+		i = bytes.Index(buf[off:], delim)
+		if i < 0 {
+			if err == io.EOF {
+				return 0, fmt.Errorf("%w - unexpected end of body", ErrInvalidMultipart)
+			}
+			// all but a possible start of the delimiter is content
+			n = copy(b, buf[:max(len(buf)-len(delim)+1, off)])
+			break
+		}
+		i += off
+		end := i + len(delim)
+		if end+1 > len(buf) && err != io.EOF {
+			// cannot tell yet whether the delimiter is followed by the end of
+			// the line - return the content before it and look again
+			n = copy(b, buf[:i])
+			break
+		}
+		// a boundary is only a delimiter if followed by "--", transport
+		// padding or the end of the line
+		if end == len(buf) || strings.IndexByte("-\r \t", buf[end]) >= 0 {
+			n = copy(b, buf[:i])
+			if n == 0 {
+				p.eof = true
+				return 0, io.EOF
+			}
+			break
+		}
+		off = i + 1
+	}
+	mr.r.Discard(n)
+	return n, nil
+}
+
+// NextPart returns the next part, or io.EOF after the last one. The content of
+// the previous part is skipped if not read to the end.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	var (
+		err  error
+		line []byte
+	)
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.current != nil {
+		_, err = io.Copy(io.Discard, mr.current)
+		if err != nil {
+			return nil, err
+		}
+		mr.current = nil
+		// the content ended at CRLF "--" boundary, of which CRLF belongs to the
+		// delimiter
+		mr.r.Discard(2)
+	}
+	for {
+		line, err = mr.readLine()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w - missing boundary", ErrInvalidMultipart)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rest, ok := bytes.CutPrefix(line, mr.boundary)
+		if !ok || (len(rest) > 0 && rest[0] != '-' && rest[0] != ' ' && rest[0] != '\t') {
+			if mr.started {
+				return nil, fmt.Errorf("%w - expected boundary", ErrInvalidMultipart)
+			}
+			// preamble before the first boundary
+			continue
+		}
+		mr.started = true
+		if bytes.HasPrefix(rest, []byte("--")) {
+			// close delimiter - anything after it is epilogue
+			mr.done = true
+			return nil, io.EOF
+		}
+		if len(bytes.Trim(rest, " \t")) > 0 {
+			return nil, fmt.Errorf("%w - malformed boundary line", ErrInvalidMultipart)
+		}
+		break
+	}
+	p := &Part{mr: mr}
+	err = mr.readPartHeaders(p)
+	if err != nil {
+		return nil, err
+	}
+	mr.current = p
+	return p, nil
+}
+
+// readLine returns the next line without its CRLF.
+func (mr *MultipartReader) readLine() ([]byte, error) {
+	line, err := mr.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w - line too long", ErrInvalidMultipart)
+	}
+	if err == io.EOF && len(line) > 0 {
+		// the close delimiter may end the body without CRLF
+		return line, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")), nil
+}
+
+func (mr *MultipartReader) readPartHeaders(p *Part) error {
+	var (
+		done bool
+		err  error
+		line []byte
+		size int
+	)
+	p.Headers = headers.NewHeaders()
+	for !done {
+		line, err = mr.r.ReadSlice('\n')
+		size += len(line)
+		if size > mr.MaxHeaderSize || err == bufio.ErrBufferFull {
+			return fmt.Errorf("%w - part headers exceed %d bytes", ErrMultipartTooLarge, mr.MaxHeaderSize)
+		}
+		if err == io.EOF {
+			return fmt.Errorf("%w - unexpected end of part headers", ErrInvalidMultipart)
+		}
+		if err != nil {
+			return err
+		}
+		_, done, err = p.Headers.Parse(line)
+		if errors.Is(err, headers.ErrMissingHeaders) {
+			// a part need not have any header fields
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w - %v", ErrInvalidMultipart, err)
+		}
+		if !done && !bytes.HasSuffix(line, []byte("\r\n")) {
+			return fmt.Errorf("%w - part header line without CRLF", ErrInvalidMultipart)
+		}
+	}
+	return nil
+}
+
+// MultipartLimits bound the forms ParseMultipartForm accepts.
+type MultipartLimits struct {
+	// MaxParts is the largest number of parts.
+	MaxParts int
+	// MaxPartSize limits the content of each part and MaxSize that of all
+	// parts together.
+	MaxPartSize int64
+	MaxSize     int64
+	// MaxMemory is how much file content is kept in memory; files beyond it
+	// are written to temporary files.
+	MaxMemory int64
+}
+
+var DefaultMultipartLimits = MultipartLimits{
+	MaxParts:    1000,
+	MaxPartSize: 10 << 20,
+	MaxSize:     32 << 20,
+	MaxMemory:   1 << 20,
+}
+
+// MultipartForm is a parsed multipart/form-data body.
+type MultipartForm struct {
+	Value map[string][]string
+	File  map[string][]*FileHeader
+}
+
+// RemoveAll removes the temporary files of the form.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, fhs := range f.File {
+		for _, fh := range fhs {
+			if fh.tmpfile != "" {
+				err := os.Remove(fh.tmpfile)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileHeader describes a file uploaded in a multipart form.
+type FileHeader struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// File is the content of an uploaded file.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error {
+	return nil
+}
+
+// Open returns the content of the file.
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return memFile{bytes.NewReader(fh.content)}, nil
+}
+
+// limitedCopy copies src to dst, failing with ErrPartTooLarge beyond limit
+// bytes.
+func limitedCopy(dst io.Writer, src io.Reader, limit int64) (int64, error) {
+	n, err := io.Copy(dst, io.LimitReader(src, limit+1))
+	if err == nil && n > limit {
+		err = fmt.Errorf("%w - more than %d bytes", ErrPartTooLarge, limit)
+	}
+	return n, err
+}
+
+// store reads the content of a file, keeping it in memory if it is at most
+// memory bytes long and writing it to a temporary file otherwise.
+func (fh *FileHeader) store(r io.Reader, memory, limit int64) (int64, error) {
+	var (
+		b   bytes.Buffer
+		err error
+		f   *os.File
+		m   int64
+		n   int64
+	)
+	n, err = io.Copy(&b, io.LimitReader(r, memory+1))
+	if err != nil {
+		return n, err
+	}
+	if n <= memory {
+		fh.content, fh.Size = b.Bytes(), n
+		return n, nil
+	}
+	f, err = os.CreateTemp("", "multipart-")
+	if err != nil {
+		return n, err
+	}
+	fh.tmpfile = f.Name()
+	_, err = f.Write(b.Bytes())
+	if err == nil {
+		m, err = limitedCopy(f, r, limit-n)
+		n += m
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	fh.Size = n
+	return n, err
+}
+
+// ParseMultipartForm parses a multipart/form-data body into MultipartForm.
+// Its plain fields are also added to PostForm and, ahead of the query
+// parameters, to Form. Files are kept in memory up to limits.MaxMemory in
+// total and written to temporary files beyond; call MultipartForm.RemoveAll
+// once done with them. Calling it again does nothing.
+func (req *Request) ParseMultipartForm(limits MultipartLimits) error {
+	var (
+		err    error
+		form   = &MultipartForm{Value: make(map[string][]string), File: make(map[string][]*FileHeader)}
+		memory = limits.MaxMemory
+		mr     *MultipartReader
+		n      int64
+		p      *Part
+		parts  int
+		query  map[string][]string
+		total  int64
+	)
+	if req.MultipartForm != nil {
+		return nil
+	}
+	mr, err = req.MultipartReader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+		}
+	}()
+	for {
+		p, err = mr.NextPart()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return err
+		}
+		parts++
+		if parts > limits.MaxParts {
+			err = fmt.Errorf("%w - more than %d parts", ErrMultipartTooLarge, limits.MaxParts)
+			return err
+		}
+		name := p.FormName()
+		if name == "" {
+			continue
+		}
+		partLimit := min(limits.MaxPartSize, limits.MaxSize-total)
+		filename := p.FileName()
+		if filename == "" {
+			var b bytes.Buffer
+			n, err = limitedCopy(&b, p, partLimit)
+			form.Value[name] = append(form.Value[name], b.String())
+		} else {
+			fh := &FileHeader{Filename: filename, Headers: p.Headers}
+			// registered first, so its temporary file is removed on error
+			form.File[name] = append(form.File[name], fh)
+			n, err = fh.store(p, min(memory, partLimit), partLimit)
+			if fh.tmpfile == "" {
+				memory -= n
+			}
+		}
+		total += n
+		if errors.Is(err, ErrPartTooLarge) && total > limits.MaxSize {
+			err = fmt.Errorf("%w - more than %d bytes", ErrMultipartTooLarge, limits.MaxSize)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	query, err = req.Query()
+	if err != nil {
+		return err
+	}
+	req.MultipartForm = form
+	req.PostForm = form.Value
+	req.Form = make(map[string][]string, len(form.Value)+len(query))
+	for k, vs := range form.Value {
+		req.Form[k] = append(req.Form[k], vs...)
+	}
+	for k, vs := range query {
+		req.Form[k] = append(req.Form[k], vs...)
+	}
+	return nil
+}
+
+// FormFile returns the first file uploaded in the field key, parsing the form
+// with DefaultMultipartLimits if needed.
+func (req *Request) FormFile(key string) (File, *FileHeader, error) {
+	err := req.ParseMultipartForm(DefaultMultipartLimits)
+	if err != nil {
+		return nil, nil, err
+	}
+	fhs := req.MultipartForm.File[key]
+	if len(fhs) == 0 {
+		return nil, nil, fmt.Errorf("Error: no file in form field %q", key)
+	}
+	f, err := fhs[0].Open()
+	return f, fhs[0], err
+}
@@ -0,0 +1,306 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func multipartRequest(t *testing.T, target, boundary string, body []byte) *Request {
+	t.Helper()
+	r, err := RequestFromReader(strings.NewReader("POST " + target + " HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: multipart/form-data; boundary=" + boundary + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		string(body)))
+	require.NoError(t, err)
+	return r
+}
+
+// capturedRequest reads a request recorded from a real client. The files in
+// testdata/multipart are unedited uploads by curl 7.88.1 (Debian 12), read off
+// a raw TCP socket on 127.0.0.1:42070:
+//
+//	curl.txt:       curl -F user=gopher -F file=@notes.txt
+//	curl-files.txt: curl -F 'title=Holiday photos' -F 'name=Zoë' \
+//	                  -F photos=@pixel.png -F photos=@cheese.txt -F 'cv=@résumé.txt'
+//
+// pixel.png is a 74 byte 1x1 PNG, the text files hold "first line\nsecond
+// line\n", "cheese\n" and "Experience: none\n".
+func capturedRequest(t *testing.T, file string) *Request {
+	t.Helper()
+	raw, err := os.ReadFile("testdata/multipart/" + file)
+	require.NoError(t, err)
+	r, err := RequestFromReader(bytes.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+func readFile(t *testing.T, fh *FileHeader) string {
+	t.Helper()
+	f, err := fh.Open()
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestMultipartReader(t *testing.T) {
+	var (
+		err  error
+		mr   *MultipartReader
+		p    *Part
+		r    *Request
+		body []byte
+	)
+	// Test: Parts are read in order with their headers
+	r = capturedRequest(t, "curl.txt")
+	mr, err = r.MultipartReader()
+	require.NoError(t, err)
+	p, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "user", p.FormName())
+	assert.Equal(t, "", p.FileName())
+	body, err = io.ReadAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", string(body))
+	p, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "file", p.FormName())
+	assert.Equal(t, "notes.txt", p.FileName())
+	assert.Equal(t, "text/plain", p.Headers.Get("content-type"))
+	body, err = io.ReadAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, "first line\nsecond line\n", string(body))
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Unread parts are skipped, one byte at a time reads work
+	r = capturedRequest(t, "curl-files.txt")
+	mr, err = r.MultipartReader()
+	require.NoError(t, err)
+	_, err = mr.NextPart()
+	require.NoError(t, err)
+	p, err = mr.NextPart()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	one := make([]byte, 1)
+	for {
+		n, err := p.Read(one)
+		buf.Write(one[:n])
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "Zoë", buf.String())
+
+	// Test: Not multipart
+	r = formRequest(t, "POST", "/", "application/x-www-form-urlencoded", "a=1")
+	_, err = r.MultipartReader()
+	assert.ErrorIs(t, err, ErrNotMultipart)
+	r = formRequest(t, "POST", "/", "multipart/form-data", "")
+	_, err = r.MultipartReader()
+	assert.ErrorIs(t, err, ErrInvalidMultipart)
+}
+
+func TestMultipartBoundaries(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		parts []string
+		err   error
+	}{
+		{
+			name:  "preamble, padding and epilogue",
+			body:  "This is the preamble.\r\n--xyz  \r\n\r\none\r\n--xyz\t\r\nX-Empty-Line: no\r\n\r\n\r\n--xyz--  \r\nThis is the epilogue.\r\n",
+			parts: []string{"one", ""},
+		},
+		{
+			name:  "boundary-like content",
+			body:  "--xyz\r\n\r\n--xyz\r\nxyz\r\n--xyzz\r\n\r\n--xyz-\r\n--xyz--",
+			parts: []string{"--xyz\r\nxyz\r\n--xyzz\r\n"},
+			err:   ErrInvalidMultipart,
+		},
+		{
+			name:  "delimiters inside lines and a bare -- line",
+			body:  "--xyz\r\n\r\nLine two with --xyz inside\r\n--\r\nNone\r\n--xyz--\r\n",
+			parts: []string{"Line two with --xyz inside\r\n--\r\nNone"},
+		},
+		{
+			name:  "close delimiter without CRLF",
+			body:  "--xyz\r\n\r\na\r\n--xyz--",
+			parts: []string{"a"},
+		},
+		{
+			name:  "empty body with only the close delimiter",
+			body:  "--xyz--\r\n",
+			parts: nil,
+		},
+		{
+			name: "missing close delimiter",
+			body: "--xyz\r\n\r\ntruncated",
+			err:  ErrInvalidMultipart,
+		},
+		{
+			name: "no boundary at all",
+			body: "just text\r\n",
+			err:  ErrInvalidMultipart,
+		},
+		{
+			name: "malformed part header",
+			body: "--xyz\r\nno colon\r\n\r\na\r\n--xyz--\r\n",
+			err:  ErrInvalidMultipart,
+		},
+		{
+			name: "LF line endings",
+			body: "--xyz\nContent-Disposition: form-data; name=a\n\na\n--xyz--\n",
+			err:  ErrInvalidMultipart,
+		},
+	}
+	for _, tt := range tests {
+		var (
+			err   error
+			got   []string
+			p     *Part
+			b     []byte
+			parts = NewMultipartReader(strings.NewReader(tt.body), "xyz")
+		)
+		for {
+			p, err = parts.NextPart()
+			if err != nil {
+				break
+			}
+			b, err = io.ReadAll(p)
+			if err != nil {
+				break
+			}
+			got = append(got, string(b))
+		}
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		} else {
+			assert.Equal(t, io.EOF, err, tt.name)
+		}
+		if tt.parts != nil {
+			assert.Equal(t, tt.parts, got, tt.name)
+		}
+	}
+
+	// Test: Delimiters straddling the read buffer
+	content := strings.Repeat("x", multipartBufSize-3)
+	parts := NewMultipartReader(strings.NewReader("--xyz\r\n\r\n"+content+"\r\n--xyz\r\n\r\nb\r\n--xyz--\r\n"), "xyz")
+	p, err := parts.NextPart()
+	require.NoError(t, err)
+	b, err := io.ReadAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, len(content), len(b))
+	p, err = parts.NextPart()
+	require.NoError(t, err)
+	b, err = io.ReadAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(b))
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	var (
+		err error
+		f   File
+		fh  *FileHeader
+		r   *Request
+	)
+	// Test: Upload with several files in a field
+	r = capturedRequest(t, "curl-files.txt")
+	require.NoError(t, r.ParseMultipartForm(DefaultMultipartLimits))
+	defer r.MultipartForm.RemoveAll()
+	assert.Equal(t, "Holiday photos", r.FormValue("title"))
+	photos := r.MultipartForm.File["photos"]
+	require.Len(t, photos, 2)
+	assert.Equal(t, "pixel.png", photos[0].Filename)
+	assert.Equal(t, "image/png", photos[0].Headers.Get("Content-Type"))
+	assert.Equal(t, int64(74), photos[0].Size)
+	assert.True(t, strings.HasPrefix(readFile(t, photos[0]), "\x89PNG\r\n\x1a\n"))
+	assert.Equal(t, "cheese.txt", photos[1].Filename)
+	assert.Equal(t, "cheese\n", readFile(t, photos[1]))
+
+	// Test: Non-ASCII values and file names, query parameters follow the body
+	r = capturedRequest(t, "curl-files.txt")
+	r.RequestLine.RequestTarget = "/upload?name=query"
+	require.NoError(t, r.ParseMultipartForm(DefaultMultipartLimits))
+	assert.Equal(t, []string{"Zoë", "query"}, r.Form["name"])
+	f, fh, err = r.FormFile("cv")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, "résumé.txt", fh.Filename)
+	assert.Equal(t, "Experience: none\n", readFile(t, fh))
+	_, _, err = r.FormFile("missing")
+	assert.Error(t, err)
+
+	// Test: Empty file inputs and percent-encoded quotes in file names, as
+	// sent by browsers
+	r = multipartRequest(t, "/", "b", []byte("--b\r\n"+
+		`Content-Disposition: form-data; name="photos"; filename="say %22cheese%22.txt"`+"\r\nContent-Type: text/plain\r\n\r\ncheese\n\r\n--b\r\n"+
+		`Content-Disposition: form-data; name="attachment"; filename=""`+"\r\nContent-Type: application/octet-stream\r\n\r\n\r\n--b--\r\n"))
+	require.NoError(t, r.ParseMultipartForm(DefaultMultipartLimits))
+	assert.Equal(t, "say %22cheese%22.txt", r.MultipartForm.File["photos"][0].Filename)
+	assert.Equal(t, []string{""}, r.MultipartForm.Value["attachment"])
+	assert.NotContains(t, r.MultipartForm.File, "attachment")
+
+	// Test: File names are stripped of directories and RFC 8187 names preferred
+	r = multipartRequest(t, "/", "b", []byte("--b\r\n"+
+		`Content-Disposition: form-data; name="a"; filename="C:\Users\gopher\My Documents\report.pdf"`+"\r\n\r\n1\r\n--b\r\n"+
+		`Content-Disposition: form-data; name="b"; filename="../../etc/passwd"`+"\r\n\r\n2\r\n--b\r\n"+
+		`Content-Disposition: form-data; name="c"; filename="fallback.txt"; filename*=UTF-8''%E2%82%AC%20rates.txt`+"\r\n\r\n3\r\n--b\r\n"+
+		`Content-Disposition: attachment; name="d"`+"\r\n\r\n4\r\n--b--\r\n"))
+	require.NoError(t, r.ParseMultipartForm(DefaultMultipartLimits))
+	assert.Equal(t, "report.pdf", r.MultipartForm.File["a"][0].Filename)
+	assert.Equal(t, "passwd", r.MultipartForm.File["b"][0].Filename)
+	assert.Equal(t, "€ rates.txt", r.MultipartForm.File["c"][0].Filename)
+	assert.NotContains(t, r.MultipartForm.Value, "d")
+
+	// Test: Files beyond MaxMemory are spilled to temporary files
+	limits := DefaultMultipartLimits
+	limits.MaxMemory = 10
+	r = capturedRequest(t, "curl-files.txt")
+	require.NoError(t, r.ParseMultipartForm(limits))
+	photos = r.MultipartForm.File["photos"]
+	require.NotEmpty(t, photos[0].tmpfile)
+	assert.Empty(t, photos[1].tmpfile, "the small file still fits in memory")
+	assert.Equal(t, int64(74), photos[0].Size)
+	assert.True(t, strings.HasPrefix(readFile(t, photos[0]), "\x89PNG"))
+	require.NoError(t, r.MultipartForm.RemoveAll())
+	_, err = os.Stat(photos[0].tmpfile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: Limits, with temporary files removed on error
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	for _, tt := range []struct {
+		limits MultipartLimits
+		err    error
+	}{
+		{MultipartLimits{MaxParts: 4, MaxPartSize: 1 << 20, MaxSize: 1 << 20, MaxMemory: 1 << 20}, ErrMultipartTooLarge},
+		{MultipartLimits{MaxParts: 10, MaxPartSize: 60, MaxSize: 1 << 20, MaxMemory: 1 << 20}, ErrPartTooLarge},
+		{MultipartLimits{MaxParts: 10, MaxPartSize: 60, MaxSize: 1 << 20, MaxMemory: 10}, ErrPartTooLarge},
+		{MultipartLimits{MaxParts: 10, MaxPartSize: 1 << 20, MaxSize: 100, MaxMemory: 10}, ErrMultipartTooLarge},
+		{MultipartLimits{MaxParts: 10, MaxPartSize: 1 << 20, MaxSize: 20, MaxMemory: 1 << 20}, ErrMultipartTooLarge},
+	} {
+		r = capturedRequest(t, "curl-files.txt")
+		err = r.ParseMultipartForm(tt.limits)
+		assert.ErrorIs(t, err, tt.err, tt.limits)
+		assert.Nil(t, r.MultipartForm)
+	}
+	entries, err := os.ReadDir(tmp)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
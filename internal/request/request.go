@@ -1,12 +1,18 @@
 package request
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"log/slog"
+	"net"
+	"net/url"
 	"strings"
 
+	"github.com/dragonicorn/httpfromtcp/internal/cookie"
+	"github.com/dragonicorn/httpfromtcp/internal/digest"
 	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/logging"
 )
 
 const BUFFER_SIZE int = 8
@@ -21,11 +27,54 @@ const (
 	requestStateDone
 )
 
+var parserStateNames = map[parserState]string{
+	requestStateInitialized:    "initialized",
+	requestStateParsingHeaders: "parsing-headers",
+	requestStateParsedHeader:   "parsed-header",
+	requestStateParsingBody:    "parsing-body",
+	requestStateDone:           "done",
+}
+
+func (s parserState) String() string {
+	if name, ok := parserStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("parserState(%d)", int(s))
+}
+
+var (
+	ErrMalformedRequestLine = errors.New("malformed request")
+	ErrUnsupportedVersion   = errors.New("unsupported version")
+	ErrInvalidContentLength = errors.New("invalid Content-Length in header")
+	ErrBodyTooShort         = errors.New("body length is less than Content-Length indicated in header")
+)
+
+var logger = logging.Discard()
+
+// SetLogger sets the logger used by Readers created afterwards. Parser traces
+// are emitted at debug level; a nil logger silences the package.
+func SetLogger(l *slog.Logger) {
+	logger = logging.OrDiscard(l)
+}
+
 type Request struct {
 	ParserState parserState
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
+	// RemoteAddr is the address of the client, set by the server.
+	RemoteAddr string
+	// Form holds the fields of a url-encoded body followed by the query
+	// parameters, and PostForm those of the body alone, once ParseForm has
+	// been called.
+	Form     url.Values
+	PostForm url.Values
+	// MultipartForm holds the parsed body once ParseMultipartForm has been
+	// called.
+	MultipartForm *MultipartForm
+
+	contentLength int
+	encodedBody   []byte // the body as received, if DecodeBody changed it
 }
 
 type RequestLine struct {
@@ -34,10 +83,6 @@ type RequestLine struct {
 	Method        string
 }
 
-func fixCRLF(str string) string {
-	return strings.Replace(strings.Replace(str, "\r", "<CR>", -1), "\n", "<LF>", -1)
-}
-
 func (req *Request) parseRequestLine(msg string) (int, error) {
 	var (
 		crlf  bool
@@ -46,8 +91,6 @@ func (req *Request) parseRequestLine(msg string) (int, error) {
 		parts []string
 	)
 	line, _, crlf = strings.Cut(msg, "\r\n")
-	// fmt.Printf("parseRequestLine msg: \"%s\"\n", msg)
-	// fmt.Printf("parseRequestLine line: \"%s\"\n", line)
 	// return number of bytes consumed - including CRLF
 	n = len(line) + 2
 	// return zero bytes consumed if no end-of-line in message
@@ -56,16 +99,21 @@ func (req *Request) parseRequestLine(msg string) (int, error) {
 	}
 	parts = strings.Split(line, " ")
 	if len(parts) != 3 {
-		return n, fmt.Errorf("malformed request - %s", line)
+		return n, fmt.Errorf("%w - %s", ErrMalformedRequestLine, line)
 	}
 	if parts[0] != strings.ToUpper(parts[0]) {
-		return n, fmt.Errorf("illegal method in request - %s", parts[0])
+		return n, fmt.Errorf("%w (illegal method) - %s", ErrMalformedRequestLine, parts[0])
 	}
-	if !strings.Contains(parts[1], "/") {
-		return n, fmt.Errorf("illegal URL in request - %s", parts[1])
+	if parts[0] == "CONNECT" {
+		// CONNECT names the host and port to tunnel to instead of a resource
+		if host, port, err := net.SplitHostPort(parts[1]); err != nil || host == "" || port == "" {
+			return n, fmt.Errorf("%w (illegal authority) - %s", ErrMalformedRequestLine, parts[1])
+		}
+	} else if !strings.Contains(parts[1], "/") {
+		return n, fmt.Errorf("%w (illegal URL) - %s", ErrMalformedRequestLine, parts[1])
 	}
 	if parts[2] != "HTTP/1.1" {
-		return n, fmt.Errorf("unsupported version - %s", parts[2])
+		return n, fmt.Errorf("%w - %s", ErrUnsupportedVersion, parts[2])
 	}
 	// fill request structure with valid data
 	req.RequestLine.Method = parts[0]
@@ -74,13 +122,12 @@ func (req *Request) parseRequestLine(msg string) (int, error) {
 	return n, nil
 }
 
-func (req *Request) parse(data []byte) (int, error) {
+func (req *Request) parse(log *slog.Logger, data []byte) (int, error) {
 	var (
 		done bool
 		n    int
 		err  error
 	)
-	fmt.Printf("\tData to parse: \"%s\" (%d bytes)\n", fixCRLF(string(data)), len(data))
 	if req.ParserState == requestStateInitialized {
 		n, err = req.parseRequestLine(string(data))
 		if n == 0 {
@@ -90,12 +137,11 @@ func (req *Request) parse(data []byte) (int, error) {
 			return n, err
 		}
 		req.ParserState = requestStateParsingHeaders
-		req.Headers = make(headers.Headers)
-		fmt.Printf("\t    request parse done - returning n: %d\n", n)
+		req.Headers = headers.NewHeaders()
 		return n, nil
 	}
 	if req.ParserState == requestStateParsingHeaders {
-		n, done, err = req.Headers.Parse(data)
+		n, done, err = req.Headers.ParseWith(log, data)
 		if n == 0 {
 			return 0, err
 		}
@@ -104,10 +150,18 @@ func (req *Request) parse(data []byte) (int, error) {
 		}
 		if done {
 			req.ParserState = requestStateParsingBody
-			fmt.Printf("\t    headers parse done - returning n: %d\n", n)
 		} else {
 			req.ParserState = requestStateParsedHeader
-			fmt.Printf("\t    header parsed - returning n: %d\n", n)
+		}
+		return n, nil
+	}
+	if req.ParserState == requestStateParsingBody {
+		// consume no more than Content-Length bytes - anything after the body
+		// belongs to the next request on the connection
+		n = min(len(data), req.contentLength-len(req.Body))
+		req.Body = append(req.Body, data[:n]...)
+		if len(req.Body) == req.contentLength {
+			req.ParserState = requestStateDone
 		}
 		return n, nil
 	}
@@ -117,154 +171,167 @@ func (req *Request) parse(data []byte) (int, error) {
 	return 0, fmt.Errorf("unknown state error")
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
+// Reader reads consecutive requests from a single connection. Bytes read
+// beyond the end of one request are retained and parsed as the start of the
+// next, so pipelined requests are returned in the order they were sent.
+type Reader struct {
+	// Logger receives the parser traces for this reader at debug level. It
+	// defaults to the package logger set with SetLogger.
+	Logger *slog.Logger
+
+	reader       io.Reader
+	buf          []byte
+	parsed, read int
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		Logger: logger,
+		reader: reader,
+		buf:    make([]byte, BUFFER_SIZE),
+	}
+}
+
+// Buffered returns the bytes read from the underlying reader that have not
+// yet been consumed by a request.
+func (r *Reader) Buffered() []byte {
+	return r.buf[r.parsed:r.read]
+}
+
+// ReadRequest parses the next request from the reader. io.EOF is returned if
+// the reader is exhausted before any bytes of a new request are received.
+func (r *Reader) ReadRequest() (*Request, error) {
 	var (
-		buf, add            []byte
-		err                 error
-		cl, n, parsed, read int
-		req                 Request
-		state               parserState // used to detect completion of parsing one entity
+		add   []byte
+		err   error
+		n     int
+		req   Request
+		state parserState // used to detect completion of parsing one entity
 	)
-	buf = make([]byte, BUFFER_SIZE)
 	req.ParserState = requestStateInitialized
 	state = req.ParserState
 	for req.ParserState != requestStateDone {
-		fmt.Printf("\nNew iteration...\n")
-
 		// parsing must occur before demanding more data or read will hang on open connections
-		if req.ParserState != requestStateParsingBody {
-			n, err = req.parse(buf[:read])
-			if err != nil {
-				fmt.Errorf("Error parsing request: %v", err)
-				return nil, err
-			}
-			// update number of bytes parsed from the buffer
-			parsed += n
+		n, err = req.parse(r.Logger, r.buf[r.parsed:r.read])
+		if err != nil {
+			return nil, err
 		}
-		// fmt.Printf("\tContents of buffer: \"%s\" (%d bytes)\n", fixCRLF(string(buf[:read])), read)
-		fmt.Printf("\t%d unparsed bytes in buffer...\n", read-parsed)
-		fmt.Printf("\tContents of buffer parsed so far: \"%s\" (%d bytes)\n", fixCRLF(string(buf[:parsed])), parsed)
-
-		if parsed == 0 && read == len(buf) {
-			fmt.Printf("\tIncreasing buffer size to ")
-			add = make([]byte, len(buf)*2)
-			copy(add, buf)
-			buf = add
-			fmt.Printf("%d/%d bytes\n", len(buf), cap(buf))
-			// } else {
-			// 	fmt.Printf("Contents of buffer parsed so far: \"%s\" (%d bytes)\n", fixCRLF(string(buf[:parsed])), parsed)
+		if n > 0 {
+			r.Logger.Debug("data consumed", "state", req.ParserState, "bytes", n, logging.CRLF("data", r.buf[r.parsed:r.parsed+n]))
 		}
+		// update number of bytes parsed from the buffer
+		r.parsed += n
 
-		if parsed == 0 {
-			// fmt.Printf("Buffer contents before io.Read: %s (read = %d)\n\n", fixCRLF(string(buf[read:])), read)
-			// n, err = io.ReadFull(reader, buf[read:])
-			n, err = reader.Read(buf[read:])
-			fmt.Printf("\t    %d bytes appended to buffer\n", n)
-			fmt.Printf("\t    Previous Buffer Data:    \"%s\" (Read: %d bytes)\n", fixCRLF(string(buf[:read])), read)
-			fmt.Printf("\t    Newly Added Buffer Data: \"%s\" (n: %d bytes)\n", fixCRLF(string(buf[read:read+n])), n)
-			fmt.Printf("\t    Entire Buffer Contents:  \"%s\" (Read + n: %d bytes)\n", fixCRLF(string(buf[0:read+n])), read+n)
-			// update number of bytes read from the reader
-			// fmt.Printf("%d bytes read from reader...\n", n)
-			read += n
-			// if err != nil {
-			if ((err == io.EOF) && (n == 0)) || (err == io.ErrUnexpectedEOF) {
-				// io.EOF error will be returned if no more data is available and NO data was read into buffer
-				// if err == io.EOF {
-				// 	if n == 0 {
-				//fmt.Printf("\tnumber of bytes read n=%d, bytes in buffer read=%d\n", n, read)
-				if req.ParserState == requestStateParsingBody {
-					fmt.Printf("\tBody Length: %d / Content-Length: %d\n", read, cl)
-					n = read
-					err = nil
-					if read > cl {
-						n = cl
-						err = fmt.Errorf("Error: body length is greater than Content-Length indicated in header")
-						fmt.Printf("%v\n", err)
-					}
-					if read < cl {
-						err = fmt.Errorf("Error: body length is less than Content-Length indicated in header")
-						fmt.Printf("%v\n", err)
-					}
-					// copy body content from buffer to request body
-					req.Body = make([]byte, n)
-					copy(req.Body, buf[0:n])
-					fmt.Printf("\t    Data consumed: (%d bytes)\n", n)
-					req.ParserState = requestStateDone
-					break
-				} else if read == 0 {
-					err = nil
-					req.ParserState = requestStateDone
-					break
-				}
-				// }
-				// io.ErrUnexpectedEOF error will be returned if no more data is available but SOME data was read into buffer
-				// } else if err != io.ErrUnexpectedEOF {
-			} else if err != nil {
-				fmt.Errorf("Error reading request: %v", err)
-				return nil, err
-			}
-		}
-		// }
-		// // update number of bytes read from the reader
-		// // fmt.Printf("%d bytes read from reader...\n", n)
-		// read += n
-		// fmt.Printf("\t%d total bytes read...\n", read)
-		// if req.ParserState != requestStateParsingBody {
-		// 	n, err = req.parse(buf[:read])
-		// 	if err != nil {
-		// 		fmt.Errorf("error parsing request - %v", err)
-		// 		return nil, err
-		// 	}
-		// 	// update number of bytes parsed from the buffer
-		// 	parsed += n
-		// }
-		// if no data parsed, increase buffer size keeping existing data
-		// if parsed == 0 && read == len(buf) {
-		// 	fmt.Printf("\tincreasing buffer size to ")
-		// 	add = make([]byte, len(buf)*2)
-		// 	copy(add, buf)
-		// 	buf = add
-		// 	fmt.Printf("%d/%d bytes\n", len(buf), cap(buf))
-		// } else {
-		// 	fmt.Printf("Contents of buffer parsed so far: \"%s\" (%d bytes)\n", fixCRLF(string(buf[:parsed])), parsed)
-		// }
 		if req.ParserState != state {
 			if req.ParserState == requestStateParsedHeader {
 				req.ParserState = requestStateParsingHeaders
 			}
 			if req.ParserState == requestStateParsingBody {
-				fmt.Println()
-				if req.Headers.Get("Content-Length") == "" {
-					fmt.Printf("\t  No Content-Length Header in request\n")
-					cl = 0
-				} else {
-					cl, err = strconv.Atoi(req.Headers.Get("Content-Length"))
-					if err != nil {
-						fmt.Printf("Error retrieving Content-Length from header: %v", err)
-						cl = 0
-					}
+				req.contentLength, err = contentLength(req.Headers)
+				if err != nil {
+					return nil, err
 				}
-				fmt.Printf("\t  Content-Length (from header): %d bytes\n", cl)
-				if cl == 0 {
+				r.Logger.Debug("headers parsed", "state", req.ParserState, "content_length", req.contentLength)
+				if req.contentLength == 0 {
 					req.ParserState = requestStateDone
 				}
 			}
 			state = req.ParserState
+			continue
+		}
+		if n > 0 {
+			continue
+		}
+
+		// remove parsed data from buffer
+		if r.parsed > 0 {
+			copy(r.buf, r.buf[r.parsed:r.read])
+			r.read -= r.parsed
+			r.parsed = 0
+		}
+		// if buffer is full without a complete entity, increase buffer size keeping existing data
+		if r.read == len(r.buf) {
+			add = make([]byte, len(r.buf)*2)
+			copy(add, r.buf)
+			r.buf = add
+			r.Logger.Debug("buffer size increased", "state", req.ParserState, "size", len(r.buf))
+		}
 
-			// remove parsed data from buffer
-			if (0 < parsed) && (parsed < len(buf)) {
-				copy(buf, buf[parsed:read])
+		n, err = r.reader.Read(r.buf[r.read:])
+		r.Logger.Debug("data read", "state", req.ParserState, "bytes", n, logging.CRLF("buffer", r.buf[:r.read+n]))
+		// update number of bytes read from the reader
+		r.read += n
+		// io.EOF error will be returned if no more data is available and NO data was read into buffer
+		// io.ErrUnexpectedEOF error will be returned if no more data is available but SOME data was read into buffer
+		if (err == io.EOF && n == 0) || err == io.ErrUnexpectedEOF {
+			if req.ParserState == requestStateParsingBody {
+				r.Logger.Debug("body truncated", "state", req.ParserState, "body_length", len(req.Body)+r.read, "content_length", req.contentLength)
+				req.Body = append(req.Body, r.buf[:r.read]...)
+				r.parsed = r.read
+				req.ParserState = requestStateDone
+				return &req, ErrBodyTooShort
+			}
+			if req.ParserState == requestStateInitialized && r.read == 0 {
+				return nil, io.EOF
 			}
-			read -= parsed
-			parsed = 0
-			fmt.Printf("\tContents of cleaned buffer: \"%s\" (%d bytes)\n", fixCRLF(string(buf[:read])), read)
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil && n == 0 {
+			return nil, err
 		}
 	}
-	// fmt.Println("----------------")
-	fmt.Printf("\n\tBody: %s (%d bytes)\n\n", fixCRLF(string(req.Body)), len(req.Body))
-	return &req, err
+	r.Logger.Debug("request parsed", "method", req.RequestLine.Method, "target", req.RequestLine.RequestTarget, logging.CRLF("body", req.Body))
+	return &req, nil
 }
 
-func main() {
+// contentLength returns the body length declared by the Content-Length header,
+// or zero if the header is absent.
+func contentLength(h headers.Headers) (int, error) {
+	var (
+		cl  int64
+		err error
+	)
+	cl, err = h.ContentLength()
+	if err != nil {
+		return 0, fmt.Errorf("%w - %s", ErrInvalidContentLength, h.Get("Content-Length"))
+	}
+	return int(max(cl, 0)), nil
+}
+
+// VerifyContentDigest checks the body as received against the Content-Digest
+// field of the request. It returns nil if the request carries no
+// Content-Digest, otherwise an error wrapping digest.ErrMismatch,
+// digest.ErrMalformedField or digest.ErrUnsupportedAlgorithm if the body cannot
+// be verified.
+func (req *Request) VerifyContentDigest() error {
+	value := req.Headers.Get("Content-Digest")
+	if value == "" {
+		return nil
+	}
+	if req.encodedBody != nil {
+		return digest.Verify(value, req.encodedBody)
+	}
+	return digest.Verify(value, req.Body)
+}
 
+// Cookies returns the cookies sent with the request. Several Cookie fields are
+// combined as if they were a single one.
+func (req *Request) Cookies() []*cookie.Cookie {
+	return cookie.Parse(strings.Join(req.Headers.Values("Cookie"), "; "))
+}
+
+// Cookie returns the first cookie called name, or an error wrapping
+// cookie.ErrNoCookie.
+func (req *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range req.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w - %s", cookie.ErrNoCookie, name)
+}
+
+// RequestFromReader parses a single request from reader. Use a Reader to parse
+// several requests from the same connection.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
 }
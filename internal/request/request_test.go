@@ -1,10 +1,16 @@
 package request
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
+	"strings"
 	"testing"
 
+	"github.com/dragonicorn/httpfromtcp/internal/cookie"
+	"github.com/dragonicorn/httpfromtcp/internal/digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -79,6 +85,20 @@ func TestRequestLineParse(t *testing.T) {
 	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
 	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
 
+	// Test: CONNECT request line with an authority
+	reader = &chunkReader{
+		data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+	for _, target := range []string{"example.com", ":443", "/"} {
+		_, err = RequestFromReader(strings.NewReader("CONNECT " + target + " HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		assert.ErrorIs(t, err, ErrMalformedRequestLine, target)
+	}
+
 	// Test: Invalid number of parts in request line
 	reader = &chunkReader{
 		data:            "/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -121,9 +141,9 @@ func TestRequestAndHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	fmt.Printf("\n\nTest: Case Insensitive Headers\n\n")
 	reader = &chunkReader{
@@ -133,9 +153,9 @@ func TestRequestAndHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	fmt.Printf("\n\nTest: Illegal header case\n\n")
 	reader = &chunkReader{
@@ -161,9 +181,9 @@ func TestRequestAndHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0, curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0, curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	fmt.Printf("\n\nTest: Malformed Header\n\n")
 	reader = &chunkReader{
@@ -178,6 +198,7 @@ func TestBodyParse(t *testing.T) {
 	var (
 		reader *chunkReader
 		r      *Request
+		rr     *Reader
 		err    error
 	)
 	fmt.Printf("\n\nTest: Standard Body\n\n")
@@ -230,10 +251,14 @@ func TestBodyParse(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	r, err = RequestFromReader(reader)
-	require.Error(t, err)
+	rr = NewReader(reader)
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
 	require.NotNil(t, r)
 	assert.Equal(t, "partial co", string(r.Body))
+	// surplus bytes are retained as the start of the next request
+	_, err = rr.ReadRequest()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 
 	fmt.Printf("\n\nTest: Large Body\n\n")
 	reader = &chunkReader{
@@ -250,3 +275,141 @@ func TestBodyParse(t *testing.T) {
 	assert.Equal(t, "What a wormderful morning it is to be a crow - with the sun shining and the rain falling, beautiful rainbow, and worms galore!\n", string(r.Body))
 
 }
+
+func TestVerifyContentDigest(t *testing.T) {
+	var (
+		r   *Request
+		err error
+	)
+	// Test: Matching Content-Digest
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 18\r\n" +
+		"Content-Digest: sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:\r\n" +
+		"\r\n" +
+		`{"hello": "world"}`))
+	require.NoError(t, err)
+	assert.NoError(t, r.VerifyContentDigest())
+
+	// Test: Mismatching Content-Digest
+	r.Body = []byte(`{"hello": "crow!"}`)
+	assert.ErrorIs(t, r.VerifyContentDigest(), digest.ErrMismatch)
+
+	// Test: No Content-Digest
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 2\r\n\r\n{}"))
+	require.NoError(t, err)
+	assert.NoError(t, r.VerifyContentDigest())
+}
+
+func TestCookies(t *testing.T) {
+	var (
+		c   *cookie.Cookie
+		r   *Request
+		err error
+	)
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Cookie: session=abc123; theme=\"dark\"\r\n" +
+		"Cookie: lang=en; bad pair; session=def456\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+
+	// Test: Cookie fields are combined and malformed pairs skipped
+	var names []string
+	for _, c := range r.Cookies() {
+		names = append(names, c.Name+"="+c.Value)
+	}
+	assert.Equal(t, []string{"session=abc123", "theme=dark", "lang=en", "session=def456"}, names)
+
+	// Test: The first cookie of a name is returned
+	c, err = r.Cookie("session")
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", c.Value)
+
+	// Test: Missing cookie
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, cookie.ErrNoCookie)
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	var (
+		reader *chunkReader
+		r      *Request
+		rr     *Reader
+		err    error
+	)
+	fmt.Printf("\n\nTest: Pipelined Requests\n\n")
+	reader = &chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"POST /third HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 6\r\n" +
+			"\r\n" +
+			"world!",
+		numBytesPerRead: 1024,
+	}
+	rr = NewReader(reader)
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.NotEmpty(t, rr.Buffered())
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, "", string(r.Body))
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+	assert.Equal(t, "world!", string(r.Body))
+	assert.Empty(t, rr.Buffered())
+
+	// a clean end of stream between requests is reported as io.EOF
+	r, err = rr.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, r)
+}
+
+func TestReaderLogger(t *testing.T) {
+	var (
+		out    bytes.Buffer
+		reader *chunkReader
+		rr     *Reader
+		err    error
+	)
+	// Test: Parser traces are silent by default
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	rr = NewReader(reader)
+	assert.False(t, rr.Logger.Enabled(context.Background(), slog.LevelError))
+
+	// Test: Parser traces are emitted at debug level with the injected logger's fields
+	reader.pos = 0
+	rr = NewReader(reader)
+	rr.Logger = slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})).With("conn", 7)
+	_, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "level=DEBUG")
+	assert.Contains(t, out.String(), "conn=7")
+	assert.Contains(t, out.String(), "state=parsing-headers")
+	assert.Contains(t, out.String(), `data="GET / HTTP/1.1<CR><LF>"`)
+	assert.NotContains(t, out.String(), "level=INFO")
+
+	// Test: Header parser traces carry the same fields
+	assert.Regexp(t, `msg="header parsed" conn=7 bytes=\d+ key=Host value=localhost:42069`, out.String())
+	assert.Regexp(t, `msg="end of headers" conn=7 `, out.String())
+}
@@ -0,0 +1,274 @@
+package response
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+)
+
+// Compression configures the compression of response bodies. It must not be
+// copied after first use.
+type Compression struct {
+	// Encodings are the supported content codings, "gzip" and "deflate", in
+	// order of preference when the client accepts several equally.
+	Encodings []string
+	// MinSize is the smallest Content-Length worth compressing. Bodies without
+	// a Content-Length are always compressed.
+	MinSize int
+	// Types lists the compressible media types. Entries ending in "/" match a
+	// whole top-level type and entries starting with "+" a structured syntax
+	// suffix.
+	Types []string
+	// Level is the compression level of the compress/flate package.
+	Level int
+
+	gzipPool sync.Pool
+	zlibPool sync.Pool
+}
+
+func NewCompression() *Compression {
+	return &Compression{
+		Encodings: []string{"gzip", "deflate"},
+		MinSize:   1024,
+		Types: []string{
+			"text/",
+			"application/javascript",
+			"application/json",
+			"application/xml",
+			"image/svg+xml",
+			"+json",
+			"+xml",
+		},
+		Level: gzip.DefaultCompression,
+	}
+}
+
+// Compressible reports whether a body of the media type contentType should be
+// compressed.
+func (c *Compression) Compressible(contentType string) bool {
+	parsed, err := headers.ParseMediaType(contentType)
+	mt := parsed.MediaType()
+	if err != nil || mt == "text/event-stream" {
+		// event streams must reach the client as soon as they are written
+		return false
+	}
+	for _, t := range c.Types {
+		switch {
+		case strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t):
+			return true
+		case strings.HasPrefix(t, "+") && strings.HasSuffix(mt, t):
+			return true
+		case t == mt:
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedCodings parses an Accept-Encoding value into the quality of each
+// listed coding.
+func acceptedCodings(acceptEncoding string) map[string]float64 {
+	qs := make(map[string]float64)
+	for _, item := range headers.ParseAccept(acceptEncoding) {
+		if item.Value == "x-gzip" {
+			item.Value = "gzip"
+		}
+		qs[item.Value] = item.Q
+	}
+	return qs
+}
+
+// Negotiate selects the content coding for a response to a request with the
+// given Accept-Encoding value, or "" to send it uncompressed. The supported
+// coding with the highest quality wins, the earlier in Encodings on a tie.
+func (c *Compression) Negotiate(acceptEncoding string) string {
+	var (
+		best     string
+		bestQ    float64
+		q        float64
+		ok       bool
+		accepted map[string]float64
+	)
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	accepted = acceptedCodings(acceptEncoding)
+	for _, coding := range c.Encodings {
+		q, ok = accepted[coding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// encoder returns a pooled compressor for coding writing to dst.
+func (c *Compression) encoder(coding string, dst io.Writer) (io.WriteCloser, func(), error) {
+	switch coding {
+	case "gzip":
+		if zw, ok := c.gzipPool.Get().(*gzip.Writer); ok {
+			zw.Reset(dst)
+			return zw, func() { c.gzipPool.Put(zw) }, nil
+		}
+		zw, err := gzip.NewWriterLevel(dst, c.Level)
+		return zw, func() { c.gzipPool.Put(zw) }, err
+	case "deflate":
+		// the "deflate" coding is the zlib format of RFC 1950
+		if zw, ok := c.zlibPool.Get().(*zlib.Writer); ok {
+			zw.Reset(dst)
+			return zw, func() { c.zlibPool.Put(zw) }, nil
+		}
+		zw, err := zlib.NewWriterLevel(dst, c.Level)
+		return zw, func() { c.zlibPool.Put(zw) }, err
+	}
+	return nil, nil, fmt.Errorf("Error: unsupported content coding %q", coding)
+}
+
+// chunkWriter frames compressed output as chunks in the body buffer.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	fmt.Fprintf(&cw.w.Body, "%x\r\n", len(p))
+	cw.w.Body.Write(p)
+	cw.w.Body.WriteString("\r\n")
+	cw.w.digestBody(p)
+	return len(p), nil
+}
+
+// compression state of a Writer
+type compressor struct {
+	config   *Compression
+	coding   string
+	enc      io.WriteCloser
+	release  func()
+	chunking bool // the Writer, not the handler, frames the body as chunks
+}
+
+// Compress enables compression of the response for a request with the given
+// Accept-Encoding value. It must be called before WriteHeaders, which decides
+// from the headers whether to compress: only bodies of a compressible
+// Content-Type, without a Content-Encoding or Content-Range, and at least
+// MinSize long are. A compressed body is always sent chunked, so the
+// Content-Length is dropped and a strong ETag weakened. Handlers keep writing
+// uncompressed bytes with WriteBody, WriteBodyFrom or WriteChunkedBody.
+func (w *Writer) Compress(c *Compression, acceptEncoding string) {
+	w.compressor = &compressor{config: c, coding: c.Negotiate(acceptEncoding)}
+}
+
+// startCompression rewrites the response headers h if the body is to be
+// compressed and prepares the encoder.
+func (w *Writer) startCompression(h *headers.Headers) error {
+	var (
+		c    *compressor = w.compressor
+		cl   int64
+		err  error
+		etag string
+	)
+	if !w.StatusCode.AllowsBody() || w.StatusCode == StatusCode206 ||
+		!c.config.Compressible(h.Get("Content-Type")) ||
+		h.Has("Content-Encoding") || h.Has("Content-Range") {
+		return nil
+	}
+	// the body depends on Accept-Encoding whether it is compressed this time or not
+	addVary(h, "Accept-Encoding")
+	if c.coding == "" {
+		return nil
+	}
+	if h.Has("Content-Length") {
+		cl, err = h.ContentLength()
+		if err == nil && cl < int64(c.config.MinSize) {
+			return nil
+		}
+		h.Del("Content-Length")
+	}
+	if etag = h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	if !h.HasToken("Transfer-Encoding", "chunked") {
+		h.Set("Transfer-Encoding", "chunked")
+		c.chunking = true
+	}
+	h.Set("Content-Encoding", c.coding)
+	c.enc, c.release, err = c.config.encoder(c.coding, chunkWriter{w})
+	return err
+}
+
+// compressing reports whether body bytes pass through an encoder.
+func (w *Writer) compressing() bool {
+	return w.compressor != nil && w.compressor.enc != nil
+}
+
+// compressFrom compresses r to the connection, sending the chunks as they are
+// produced rather than holding the whole body in Body.
+func (w *Writer) compressFrom(r io.Reader) (int64, error) {
+	var (
+		buf  = make([]byte, 32*1024)
+		err  error
+		n    int
+		rerr error
+		read int64
+	)
+	for {
+		n, rerr = r.Read(buf)
+		if n > 0 {
+			read += int64(n)
+			_, err = w.compressor.enc.Write(buf[:n])
+			if err == nil {
+				_, err = w.Body.WriteTo(w.Writer)
+			}
+			if err != nil {
+				return read, err
+			}
+		}
+		if rerr == io.EOF {
+			return read, nil
+		}
+		if rerr != nil {
+			return read, rerr
+		}
+	}
+}
+
+// finishCompression flushes the encoder, ending the compressed body.
+func (w *Writer) finishCompression() error {
+	c := w.compressor
+	if c == nil || c.enc == nil {
+		return nil
+	}
+	err := c.enc.Close()
+	c.release()
+	c.enc = nil
+	return err
+}
+
+// Close completes the response: it ends a body compressed on behalf of the
+// handler and writes anything still buffered in Body to the connection. The
+// server closes every Writer after its handler returns.
+func (w *Writer) Close() error {
+	var (
+		err error
+	)
+	if w.compressor != nil && w.compressor.enc != nil && w.compressor.chunking {
+		err = w.finishCompression()
+		if err == nil {
+			w.Body.WriteString("0\r\n\r\n")
+		}
+	}
+	if err == nil {
+		_, err = w.Body.WriteTo(w.Writer)
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	c := NewCompression()
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"GZIP ; Q=0.8", "gzip"},
+		{"x-gzip", "gzip"},
+		{"*", "gzip"},
+		{"*;q=0.1, gzip;q=0", "deflate"},
+		{"br, identity", ""},
+		{"gzip;q=invalid", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, c.Negotiate(tt.acceptEncoding), tt.acceptEncoding)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	c := NewCompression()
+	assert.True(t, c.Compressible("text/html"))
+	assert.True(t, c.Compressible("Text/Plain; charset=utf-8"))
+	assert.True(t, c.Compressible("application/json"))
+	assert.True(t, c.Compressible("application/problem+json"))
+	assert.True(t, c.Compressible("image/svg+xml"))
+	assert.False(t, c.Compressible("image/png"))
+	assert.False(t, c.Compressible("video/mp4"))
+	assert.False(t, c.Compressible("text/event-stream"))
+	assert.False(t, c.Compressible(""))
+}
+
+// readResponse parses the response in buf and returns its body as sent
+func readResponse(t *testing.T, buf *bytes.Buffer) (*http.Response, []byte) {
+	t.Helper()
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, body
+}
+
+func TestCompress(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		body []byte
+		c    *Compression
+		err  error
+		h    headers.Headers
+		msg  string
+		res  *http.Response
+		w    *Writer
+	)
+	c = NewCompression()
+	msg = strings.Repeat("<p>Your request was an absolute banger.</p>\n", 50)
+
+	// Test: A Content-Length body is sent gzipped and chunked
+	w = NewWriter(&buf)
+	w.Compress(c, "gzip, deflate")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	h = GetDefaultHeaders(len(msg))
+	h.Set("ETag", `"v1"`)
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte(msg))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	res, body = readResponse(t, &buf)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	assert.Equal(t, `W/"v1"`, res.Header.Get("ETag"))
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Less(t, len(body), len(msg))
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(plain))
+
+	// Test: A streamed body is deflated
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Compress(c, "deflate")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(msg))))
+	_, err = w.WriteBodyFrom(strings.NewReader(msg))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	res, body = readResponse(t, &buf)
+	assert.Equal(t, "deflate", res.Header.Get("Content-Encoding"))
+	zlr, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	plain, err = io.ReadAll(zlr)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(plain))
+
+	// Test: A chunked body is compressed and keeps its trailers
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Compress(c, "gzip")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	h = headers.Headers{{Name: "Content-Type", Value: "application/json"}, {Name: "Transfer-Encoding", Value: "chunked"}, {Name: "Trailer", Value: "X-Done"}, {Name: "Vary", Value: "Origin"}}
+	require.NoError(t, w.WriteHeaders(h))
+	w.State = StateChunkedBody
+	for range 3 {
+		_, err = w.WriteChunkedBody([]byte(`{"hello": "world"}`))
+		require.NoError(t, err)
+	}
+	w.State = StateChunkedBodyDone
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	_, err = w.Body.WriteTo(w.Writer)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{{Name: "X-Done", Value: "yes"}}))
+	require.NoError(t, w.Close())
+	res, body = readResponse(t, &buf)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Origin, Accept-Encoding", res.Header.Get("Vary"))
+	assert.Equal(t, "yes", res.Trailer.Get("X-Done"))
+	zr, err = gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	plain, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat(`{"hello": "world"}`, 3), string(plain))
+
+	// Test: Bodies below the threshold are sent as they are
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Compress(c, "gzip")
+	require.NoError(t, w.WriteError(StatusCode404, nil))
+	require.NoError(t, w.Close())
+	res, body = readResponse(t, &buf)
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	assert.Equal(t, "404 Not Found\n", string(body))
+
+	// Test: Incompressible types and clients not accepting an encoding get no compression
+	for _, tt := range []struct{ contentType, acceptEncoding, vary string }{
+		{"video/mp4", "gzip", ""},
+		{"text/html", "br", "Accept-Encoding"},
+	} {
+		buf.Reset()
+		w = NewWriter(&buf)
+		w.Compress(c, tt.acceptEncoding)
+		require.NoError(t, w.WriteStatusLine(StatusCode200))
+		h = GetDefaultHeaders(len(msg))
+		h.Set("Content-Type", tt.contentType)
+		require.NoError(t, w.WriteHeaders(h))
+		_, err = w.WriteBody([]byte(msg))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		res, body = readResponse(t, &buf)
+		assert.Empty(t, res.Header.Get("Content-Encoding"), tt.contentType)
+		assert.Equal(t, tt.vary, res.Header.Get("Vary"), tt.contentType)
+		assert.Equal(t, int64(len(msg)), res.ContentLength, tt.contentType)
+		assert.Equal(t, msg, string(body), tt.contentType)
+	}
+}
@@ -0,0 +1,130 @@
+package response
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+)
+
+// etagList splits an If-Match or If-None-Match value into entity tags. Commas
+// inside quoted tags are kept.
+func etagList(s string) []string {
+	var (
+		tags []string
+		tag  string
+	)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return tags
+		}
+		if s[0] == '*' {
+			tags = append(tags, "*")
+			s = s[1:]
+			continue
+		}
+		tag = ""
+		if strings.HasPrefix(s, "W/") {
+			tag, s = "W/", s[2:]
+		}
+		if s == "" || s[0] != '"' {
+			// malformed - skip to the next element
+			_, s, _ = strings.Cut(s, ",")
+			continue
+		}
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 {
+			return tags
+		}
+		tags = append(tags, tag+s[:end+2])
+		s = s[end+2:]
+	}
+}
+
+// etagMatch compares two entity tags, weakly ignoring the W/ prefix or strongly
+// requiring both to be strong.
+func etagMatch(a, b string, weak bool) bool {
+	if weak {
+		return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+	}
+	return a == b && !strings.HasPrefix(a, "W/")
+}
+
+func anyETagMatches(list string, etag string, weak bool) bool {
+	for _, tag := range etagList(list) {
+		if tag == "*" || (etag != "" && etagMatch(tag, etag, weak)) {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluatePreconditions checks the conditional headers of a request against
+// the current entity tag and modification time of the target representation,
+// either of which may be empty. They are evaluated in the order required by RFC
+// 9110 section 13.2.2. It returns StatusCode304 or StatusCode412 if the request
+// must be answered with that status, or zero if it should be served normally.
+func EvaluatePreconditions(method string, reqHeaders headers.Headers, etag string, modtime time.Time) StatusCode {
+	var (
+		get bool = method == "GET" || method == "HEAD"
+		err error
+		t   time.Time
+	)
+	modtime = modtime.Truncate(time.Second)
+	if v := reqHeaders.Get("If-Match"); v != "" {
+		if !anyETagMatches(v, etag, false) {
+			return StatusCode412
+		}
+	} else if v := reqHeaders.Get("If-Unmodified-Since"); v != "" && !modtime.IsZero() {
+		if t, err = headers.ParseTime(v); err == nil && modtime.After(t) {
+			return StatusCode412
+		}
+	}
+	if v := reqHeaders.Get("If-None-Match"); v != "" {
+		if anyETagMatches(v, etag, true) {
+			if get {
+				return StatusCode304
+			}
+			return StatusCode412
+		}
+	} else if v := reqHeaders.Get("If-Modified-Since"); v != "" && get && !modtime.IsZero() {
+		if t, err = headers.ParseTime(v); err == nil && !modtime.After(t) {
+			return StatusCode304
+		}
+	}
+	return 0
+}
+
+// WritePreconditions evaluates the conditional request headers as
+// EvaluatePreconditions does. If the request should not be served normally, a
+// 304 Not Modified or 412 Precondition Failed response without a body is
+// written and true returned; the handler must then not write anything else.
+func (w *Writer) WritePreconditions(method string, reqHeaders headers.Headers, etag string, modtime time.Time) (bool, error) {
+	var (
+		err error
+		h   headers.Headers
+		sc  StatusCode
+	)
+	sc = EvaluatePreconditions(method, reqHeaders, etag, modtime)
+	if sc == 0 {
+		return false, nil
+	}
+	err = w.WriteStatusLine(sc)
+	if err != nil {
+		return true, err
+	}
+	h = GetDefaultHeaders(0)
+	h.Del("Content-Type")
+	if sc == StatusCode304 {
+		// a 304 describes the representation the client already has
+		h.Del("Content-Length")
+		if etag != "" {
+			h.Set("ETag", etag)
+		}
+		if !modtime.IsZero() {
+			h.Set("Last-Modified", headers.FormatTime(modtime))
+		}
+	}
+	return true, w.WriteHeaders(h)
+}
@@ -0,0 +1,94 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluatePreconditions(t *testing.T) {
+	var (
+		etag    string    = `"v2"`
+		modtime time.Time = time.Date(2024, time.May, 1, 12, 0, 0, 500, time.UTC)
+	)
+	const (
+		before = "Tue, 30 Apr 2024 12:00:00 GMT"
+		same   = "Wed, 01 May 2024 12:00:00 GMT"
+		after  = "Thu, 02 May 2024 12:00:00 GMT"
+	)
+	tests := []struct {
+		name    string
+		method  string
+		headers headers.Headers
+		want    StatusCode
+	}{
+		{"no conditions", "GET", headers.Headers{}, 0},
+		{"If-None-Match matches", "GET", headers.Headers{{Name: "if-none-match", Value: `"v1", "v2"`}}, StatusCode304},
+		{"If-None-Match weak comparison", "HEAD", headers.Headers{{Name: "if-none-match", Value: `W/"v2"`}}, StatusCode304},
+		{"If-None-Match star", "GET", headers.Headers{{Name: "if-none-match", Value: "*"}}, StatusCode304},
+		{"If-None-Match differs", "GET", headers.Headers{{Name: "if-none-match", Value: `"v1"`}}, 0},
+		{"If-None-Match on unsafe method", "PUT", headers.Headers{{Name: "if-none-match", Value: "*"}}, StatusCode412},
+		{"If-None-Match with comma in tag", "GET", headers.Headers{{Name: "if-none-match", Value: `"a,b", "v2"`}}, StatusCode304},
+		{"If-Match matches", "PUT", headers.Headers{{Name: "if-match", Value: `"v2"`}}, 0},
+		{"If-Match star", "PUT", headers.Headers{{Name: "if-match", Value: "*"}}, 0},
+		{"If-Match differs", "PUT", headers.Headers{{Name: "if-match", Value: `"v1"`}}, StatusCode412},
+		{"If-Match strong comparison", "PUT", headers.Headers{{Name: "if-match", Value: `W/"v2"`}}, StatusCode412},
+		{"If-Modified-Since not modified", "GET", headers.Headers{{Name: "if-modified-since", Value: same}}, StatusCode304},
+		{"If-Modified-Since modified", "GET", headers.Headers{{Name: "if-modified-since", Value: before}}, 0},
+		{"If-Modified-Since obsolete RFC 850 date", "GET", headers.Headers{{Name: "if-modified-since", Value: "Thursday, 02-May-24 12:00:00 GMT"}}, StatusCode304},
+		{"If-Modified-Since asctime date", "GET", headers.Headers{{Name: "if-modified-since", Value: "Thu May  2 12:00:00 2024"}}, StatusCode304},
+		{"If-Modified-Since invalid date", "GET", headers.Headers{{Name: "if-modified-since", Value: "yesterday"}}, 0},
+		{"If-Modified-Since ignored for POST", "POST", headers.Headers{{Name: "if-modified-since", Value: after}}, 0},
+		{"If-Unmodified-Since unmodified", "PUT", headers.Headers{{Name: "if-unmodified-since", Value: same}}, 0},
+		{"If-Unmodified-Since modified", "PUT", headers.Headers{{Name: "if-unmodified-since", Value: before}}, StatusCode412},
+		// precedence
+		{"If-Match overrides If-Unmodified-Since", "PUT", headers.Headers{{Name: "if-match", Value: `"v2"`}, {Name: "if-unmodified-since", Value: before}}, 0},
+		{"If-None-Match overrides If-Modified-Since", "GET", headers.Headers{{Name: "if-none-match", Value: `"v1"`}, {Name: "if-modified-since", Value: after}}, 0},
+		{"If-Match failure before If-None-Match", "GET", headers.Headers{{Name: "if-match", Value: `"v1"`}, {Name: "if-none-match", Value: `"v2"`}}, StatusCode412},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, EvaluatePreconditions(tt.method, tt.headers, etag, modtime), tt.name)
+	}
+}
+
+func TestWritePreconditions(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		done bool
+		err  error
+		w    *Writer
+	)
+	modtime := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	// Test: 304 carries the validators and no body
+	w = NewWriter(&buf)
+	done, err = w.WritePreconditions("GET", headers.Headers{{Name: "if-none-match", Value: `"v1"`}}, `"v1"`, modtime)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Contains(t, buf.String(), "HTTP/1.1 304 Not Modified\r\n")
+	assert.Contains(t, buf.String(), "ETag: \"v1\"\r\n")
+	assert.Contains(t, buf.String(), "Last-Modified: Wed, 01 May 2024 12:00:00 GMT\r\n")
+	assert.NotContains(t, buf.String(), "Content-Length")
+	assert.Equal(t, int64(0), w.BytesWritten())
+
+	// Test: 412 has an empty body
+	buf.Reset()
+	w = NewWriter(&buf)
+	done, err = w.WritePreconditions("DELETE", headers.Headers{{Name: "if-match", Value: `"v0"`}}, `"v1"`, modtime)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Contains(t, buf.String(), "HTTP/1.1 412 Precondition Failed\r\n")
+	assert.Contains(t, buf.String(), "Content-Length: 0\r\n")
+
+	// Test: Nothing is written when the request should be served
+	buf.Reset()
+	w = NewWriter(&buf)
+	done, err = w.WritePreconditions("GET", headers.Headers{}, `"v1"`, modtime)
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, 0, buf.Len())
+}
@@ -0,0 +1,30 @@
+package response
+
+import (
+	"fmt"
+
+	"github.com/dragonicorn/httpfromtcp/internal/cookie"
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+)
+
+// SetCookie adds a Set-Cookie field for each cookie to the headers written
+// next by WriteHeaders or WriteError. It must be called before the headers are
+// written, and sets none of the cookies if any of them is invalid.
+func (w *Writer) SetCookie(cookies ...*cookie.Cookie) error {
+	var (
+		err    error
+		fields headers.Headers
+	)
+	if w.State > StateHeader {
+		return fmt.Errorf("Error: setting cookies after the response headers")
+	}
+	for _, c := range cookies {
+		err = c.Valid()
+		if err != nil {
+			return err
+		}
+		fields.Add("Set-Cookie", c.String())
+	}
+	w.cookies = append(w.cookies, fields...)
+	return nil
+}
@@ -0,0 +1,51 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dragonicorn/httpfromtcp/internal/cookie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCookie(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		err error
+		w   *Writer
+	)
+	// Test: Each cookie gets its own Set-Cookie field
+	w = NewWriter(&buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1"}, &cookie.Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "c", Value: "3", Path: "/"}))
+	h := GetDefaultHeaders(2)
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	res, _ := readResponse(t, &buf)
+	assert.Equal(t, []string{"a=1", "b=2; HttpOnly", "c=3; Path=/"}, res.Header.Values("Set-Cookie"))
+	assert.False(t, h.Has("Set-Cookie"), "the handler's headers are left as they are")
+
+	// Test: Cookies are sent with error responses
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "id", MaxAge: -1}))
+	require.NoError(t, w.WriteError(StatusCode403, nil))
+	require.NoError(t, w.Close())
+	res, _ = readResponse(t, &buf)
+	assert.Equal(t, "id=; Max-Age=0", res.Header.Get("Set-Cookie"))
+
+	// Test: An invalid cookie sets none
+	w = NewWriter(&buf)
+	err = w.SetCookie(&cookie.Cookie{Name: "ok", Value: "1"}, &cookie.Cookie{Name: "bad", Value: "a b"})
+	assert.ErrorIs(t, err, cookie.ErrInvalidCookie)
+	assert.Empty(t, w.cookies)
+
+	// Test: Too late once the headers are written
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "late", Value: "1"}))
+}
@@ -0,0 +1,87 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+)
+
+var (
+	ErrHijacked      = errors.New("connection has been hijacked")
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
+)
+
+// SetConn tells w the connection it writes to, for Hijack, and how to get the
+// bytes read from it beyond the current request. The server calls it for every
+// Writer it passes to a handler; without it Hijack only works if w writes to a
+// net.Conn directly, and returns no buffered bytes.
+func (w *Writer) SetConn(conn net.Conn, buffered func() []byte) {
+	w.conn = conn
+	w.buffered = buffered
+}
+
+// Conn returns the connection w writes to, or nil if it is not writing to a
+// connection. Unlike Hijack it leaves the connection to the server. Anything
+// read from it is lost to the server, which would otherwise parse it as the
+// next request, so a handler reading from it should answer with
+// Connection: close.
+func (w *Writer) Conn() net.Conn {
+	var (
+		conn net.Conn = w.conn
+	)
+	if conn == nil && w.counter != nil {
+		conn, _ = w.counter.w.(net.Conn)
+	} else if conn == nil {
+		conn, _ = w.Writer.(net.Conn)
+	}
+	return conn
+}
+
+// Hijack takes the connection over from the server, for protocols that
+// continue on it after an HTTP exchange, such as WebSocket, CONNECT tunnels or
+// other upgrades. It returns the connection along with the bytes the server
+// had already read from it past the current request - the start of the new
+// protocol, or of further pipelined requests - which must be processed before
+// anything read from the connection.
+//
+// Any body still buffered in w is flushed first, so the handler can write a
+// response such as 101 Switching Protocols and then hijack. Hijack fails once
+// w has started compressing a body, or if w is not writing to a connection.
+//
+// After a successful Hijack w cannot be written to, and the server no longer
+// manages the connection: it neither reads further requests from it nor
+// closes it, even once the handler has returned. Closing it is up to the
+// caller.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	var (
+		buffered []byte
+		conn     net.Conn = w.Conn()
+		err      error
+	)
+	if w.State == StateHijacked {
+		return nil, nil, ErrHijacked
+	}
+	if conn == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if w.compressing() {
+		return nil, nil, fmt.Errorf("%w - response body is being compressed", ErrNotHijackable)
+	}
+	_, err = w.Body.WriteTo(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.buffered != nil {
+		// the server's read buffer is not ours to keep
+		buffered = slices.Clone(w.buffered())
+	}
+	w.State = StateHijacked
+	return conn, buffered, nil
+}
+
+// Hijacked reports whether the connection of w has been taken over with
+// Hijack.
+func (w *Writer) Hijacked() bool {
+	return w.State == StateHijacked
+}
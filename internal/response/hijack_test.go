@@ -0,0 +1,82 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHijack(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		err error
+		w   *Writer
+	)
+	// Test: The connection is returned with the buffered body flushed
+	server, client := net.Pipe()
+	defer client.Close()
+	w = NewWriter(server)
+	go func() {
+		assert.NoError(t, w.WriteStatusLine(StatusCode101))
+		h := GetDefaultHeaders(0)
+		h.Del("Content-Length")
+		assert.NoError(t, w.WriteHeaders(h))
+		_, err := w.WriteBody([]byte("early"))
+		assert.NoError(t, err)
+		conn, buffered, err := w.Hijack()
+		assert.NoError(t, err)
+		assert.Empty(t, buffered)
+		assert.True(t, w.Hijacked())
+		_, err = conn.Write([]byte(" raw"))
+		assert.NoError(t, err)
+		conn.Close()
+	}()
+	got, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Contains(t, string(got), "HTTP/1.1 101 Switching Protocols\r\n")
+	assert.True(t, bytes.HasSuffix(got, []byte("\r\n\r\nearly raw")))
+
+	// Test: Nothing more can be written or hijacked
+	_, err = w.WriteBody([]byte("late"))
+	assert.Error(t, err)
+	assert.NoError(t, w.Close())
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrHijacked)
+
+	// Test: Bytes read beyond the request are returned as a copy
+	server, client = net.Pipe()
+	defer client.Close()
+	readBuf := []byte("GET / HTTP/1.1\r\n\r\nearly")
+	w = NewWriter(&buf)
+	w.SetConn(server, func() []byte { return readBuf[18:] })
+	conn, buffered, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.Equal(t, "early", string(buffered))
+	buffered[0] = 'E'
+	assert.Equal(t, "early", string(readBuf[18:]))
+
+	// Test: Writers not on a connection or compressing cannot be hijacked
+	w = NewWriter(&buf)
+	assert.Nil(t, w.Conn())
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+	assert.False(t, w.Hijacked())
+	server, client = net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	w = NewWriter(server)
+	assert.Equal(t, server, w.Conn())
+	w.Compress(NewCompression(), "gzip")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	h := GetDefaultHeaders(0)
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+}
@@ -0,0 +1,99 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+)
+
+// Problem is an RFC 9457 problem details object, written by WriteProblem as an
+// application/problem+json body.
+type Problem struct {
+	// Type is a URI identifying the problem type; empty means "about:blank",
+	// whose problems are described by their status alone.
+	Type string
+	// Title is a short summary of the problem type, defaulting to the reason
+	// phrase of Status for "about:blank" problems.
+	Title  string
+	Status StatusCode
+	// Detail explains this occurrence of the problem to the client.
+	Detail string
+	// Instance is a URI identifying this occurrence of the problem.
+	Instance string
+	// Extensions are additional members. They cannot replace the standard
+	// ones.
+	Extensions map[string]any
+}
+
+// MarshalJSON encodes the problem as a single object with the extension
+// members alongside the standard ones, leaving out empty members.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	for k, v := range map[string]string{"type": p.Type, "title": p.Title, "detail": p.Detail, "instance": p.Instance} {
+		delete(m, k)
+		if v != "" {
+			m[k] = v
+		}
+	}
+	delete(m, "status")
+	if p.Status != 0 {
+		m["status"] = int(p.Status)
+	}
+	return json.Marshal(m)
+}
+
+// reasonText returns the reason phrase of statusCode without the code.
+func reasonText(statusCode StatusCode) string {
+	return strings.TrimPrefix(ReasonPhrases[statusCode], strconv.Itoa(int(statusCode))+" ")
+}
+
+// writeJSON writes a complete response for statusCode with v encoded as its
+// body.
+func (w *Writer) writeJSON(statusCode StatusCode, contentType string, v any, extra headers.Headers) error {
+	var (
+		b   bytes.Buffer
+		err error
+		h   headers.Headers
+	)
+	// encoding first leaves the response unstarted if v cannot be encoded
+	err = json.NewEncoder(&b).Encode(v)
+	if err != nil {
+		return fmt.Errorf("Error encoding JSON response: %v", err)
+	}
+	err = w.WriteStatusLine(statusCode)
+	if err == nil {
+		h = GetDefaultHeaders(b.Len())
+		h.Set("Content-Type", contentType)
+		err = w.WriteHeaders(mergeHeaders(h, extra))
+		if err == nil {
+			_, err = w.WriteBody(b.Bytes())
+		}
+	}
+	return err
+}
+
+// WriteJSON writes a complete response for statusCode with v encoded as an
+// application/json body. extra headers are added to the defaults.
+func (w *Writer) WriteJSON(statusCode StatusCode, v any, extra headers.Headers) error {
+	return w.writeJSON(statusCode, "application/json", v, extra)
+}
+
+// WriteProblem writes a complete response for the status of p, or 500 if it
+// has none, with p as an application/problem+json body. extra headers are
+// added to the defaults.
+func (w *Writer) WriteProblem(p Problem, extra headers.Headers) error {
+	if p.Status == 0 {
+		p.Status = StatusCode500
+	}
+	if (p.Type == "" || p.Type == "about:blank") && p.Title == "" {
+		p.Title = reasonText(p.Status)
+	}
+	return w.writeJSON(p.Status, "application/problem+json", p, extra)
+}
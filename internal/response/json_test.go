@@ -0,0 +1,82 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJSON(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		w   *Writer
+	)
+	// Test: Status, content type and length
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteJSON(StatusCode200, map[string]any{"name": "pen", "tags": []string{"a<b"}}, headers.Headers{{Name: "Cache-Control", Value: "no-store"}}))
+	require.NoError(t, w.Close())
+	res, body := readResponse(t, &buf)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+	assert.Equal(t, int64(len(body)), res.ContentLength)
+	assert.JSONEq(t, `{"name": "pen", "tags": ["a<b"]}`, string(body))
+
+	// Test: Values that cannot be encoded leave the response unstarted
+	w = NewWriter(&buf)
+	assert.Error(t, w.WriteJSON(StatusCode200, math.Inf(1), nil))
+	assert.Equal(t, WriteState(StateStatus), w.State)
+}
+
+func TestWriteProblem(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		m   map[string]any
+		w   *Writer
+	)
+	// Test: About:blank problems take the reason phrase as title
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteProblem(Problem{Status: StatusCode404, Detail: "No item 42."}, nil))
+	require.NoError(t, w.Close())
+	res, body := readResponse(t, &buf)
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, "application/problem+json", res.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"title": "Not Found", "status": 404, "detail": "No item 42."}`, string(body))
+
+	// Test: Typed problems with extension members
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteProblem(Problem{
+		Type:       "https://example.com/probs/out-of-credit",
+		Title:      "You do not have enough credit.",
+		Status:     StatusCode403,
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]any{"balance": 30, "status": "ignored", "accounts": []string{"/account/12345"}},
+	}, headers.Headers{{Name: "Content-Language", Value: "en"}}))
+	require.NoError(t, w.Close())
+	res, body = readResponse(t, &buf)
+	assert.Equal(t, "en", res.Header.Get("Content-Language"))
+	require.NoError(t, json.Unmarshal(body, &m))
+	assert.Equal(t, map[string]any{
+		"type":     "https://example.com/probs/out-of-credit",
+		"title":    "You do not have enough credit.",
+		"status":   float64(403),
+		"instance": "/account/12345/msgs/abc",
+		"balance":  float64(30),
+		"accounts": []any{"/account/12345"},
+	}, m)
+
+	// Test: Problems without a status are server errors
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteProblem(Problem{}, nil))
+	require.NoError(t, w.Close())
+	res, body = readResponse(t, &buf)
+	assert.Equal(t, 500, res.StatusCode)
+	assert.JSONEq(t, `{"title": "Internal Server Error", "status": 500}`, string(body))
+}
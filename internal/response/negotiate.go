@@ -0,0 +1,100 @@
+package response
+
+import (
+	"strings"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+)
+
+// addVary adds the field names to the Vary field of h unless they, or "*", are
+// already listed.
+func addVary(h *headers.Headers, names ...string) {
+	if h.HasToken("Vary", "*") {
+		return
+	}
+	for _, name := range names {
+		if h.HasToken("Vary", name) {
+			continue
+		}
+		if vary := h.Get("Vary"); vary != "" {
+			h.Set("Vary", vary+", "+name)
+		} else {
+			h.Set("Vary", name)
+		}
+	}
+}
+
+// Vary adds the request field names to the Vary field of the headers written
+// next by WriteHeaders or WriteError, for responses whose content depends on
+// them. It has no effect once the headers are written.
+func (w *Writer) Vary(names ...string) {
+	w.vary = append(w.vary, names...)
+}
+
+// mediaRangeMatch returns how specifically the media range r matches mt: 0 for
+// no match, then 1 for */*, 2 for type/* and 3 for type/subtype, plus one for
+// each parameter of r, all of which mt must have.
+func mediaRangeMatch(r headers.AcceptItem, mt headers.MediaType) int {
+	var (
+		ok       bool
+		rt, rsub string
+		score    int
+	)
+	rt, rsub, ok = strings.Cut(r.Value, "/")
+	switch {
+	case !ok:
+		return 0
+	case rt == "*" && rsub == "*":
+		score = 1
+	case rt == mt.Type && rsub == "*":
+		score = 2
+	case rt == mt.Type && rsub == mt.Subtype:
+		score = 3
+	default:
+		return 0
+	}
+	for name, value := range r.Params {
+		if !strings.EqualFold(mt.Params[name], value) {
+			return 0
+		}
+		score++
+	}
+	return score
+}
+
+// NegotiateContentType selects the offered media type best matching an Accept
+// field value, or "" if none is acceptable. Each offer takes the quality of
+// the most specific media range matching it; the offer with the highest
+// quality wins, then the one matched more specifically, then the earlier one.
+// Without an Accept field every offer is acceptable and the first is chosen.
+func NegotiateContentType(accept string, offers ...string) string {
+	var (
+		best      string
+		bestQ     float64
+		bestScore int
+		items     []headers.AcceptItem
+	)
+	if strings.TrimSpace(accept) == "" {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+	items = headers.ParseAccept(accept)
+	for _, offer := range offers {
+		mt, err := headers.ParseMediaType(offer)
+		if err != nil {
+			continue
+		}
+		q, score := 0.0, 0
+		for _, item := range items {
+			if s := mediaRangeMatch(item, mt); s > score {
+				q, score = item.Q, s
+			}
+		}
+		if q > bestQ || (q == bestQ && q > 0 && score > bestScore) {
+			best, bestQ, bestScore = offer, q, score
+		}
+	}
+	return best
+}
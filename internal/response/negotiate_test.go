@@ -0,0 +1,81 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"text/html", "application/json", "text/plain"}
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+	}{
+		// no preference
+		{"", offers, "text/html"},
+		{"*/*", offers, "text/html"},
+		{"", nil, ""},
+		// quality
+		{"application/json", offers, "application/json"},
+		{"text/plain;q=0.5, application/json;q=0.9", offers, "application/json"},
+		{"text/*;q=0.8, */*;q=0.1", []string{"application/json", "text/plain"}, "text/plain"},
+		{"text/html;q=0.1, text/plain;q=0.1", offers, "text/html"},
+		// the most specific range sets the quality
+		{"text/*, text/html;q=0", offers, "text/plain"},
+		{"*/*;q=0.5, application/json", offers, "application/json"},
+		{"text/html;q=0, */*", []string{"text/html"}, ""},
+		// equal quality prefers the more specific match
+		{"text/*, application/json", offers, "application/json"},
+		// case and parameters
+		{"Application/JSON", offers, "application/json"},
+		{"text/html;level=1, text/plain;q=0.5", []string{"text/html", "text/plain"}, "text/plain"},
+		{"text/html;level=1, text/plain;q=0.5", []string{"text/html;level=1", "text/plain"}, "text/html;level=1"},
+		{"text/plain; charset=utf-8", []string{"text/plain; charset=UTF-8"}, "text/plain; charset=UTF-8"},
+		// nothing acceptable
+		{"image/png", offers, ""},
+		{"application/json;q=0", offers, ""},
+		{"garbage", offers, ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NegotiateContentType(tt.accept, tt.offers...), tt.accept)
+	}
+}
+
+func TestVary(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		h   headers.Headers
+		w   *Writer
+	)
+	// Test: Names are added to the handler's Vary field once
+	w = NewWriter(&buf)
+	w.Vary("Accept", "Accept-Language")
+	w.Vary("accept")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	h = GetDefaultHeaders(0)
+	h.Set("Vary", "Origin")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "Origin, Accept, Accept-Language", w.Headers.Get("Vary"))
+	assert.Equal(t, "Origin", h.Get("Vary"), "the handler's headers are left as they are")
+
+	// Test: Vary: * already covers every field
+	w = NewWriter(&buf)
+	w.Vary("Accept")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	h = GetDefaultHeaders(0)
+	h.Set("Vary", "*")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "*", w.Headers.Get("Vary"))
+
+	// Test: Combined with compression
+	w = NewWriter(&buf)
+	w.Vary("Accept")
+	w.Compress(NewCompression(), "gzip")
+	require.NoError(t, w.WriteError(StatusCode404, nil))
+	assert.Equal(t, "Accept, Accept-Encoding", w.Headers.Get("Vary"))
+}
@@ -4,27 +4,63 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
 
+	"github.com/dragonicorn/httpfromtcp/internal/digest"
 	"github.com/dragonicorn/httpfromtcp/internal/headers"
 )
 
 type StatusCode int
 
 const (
-	StatusCode200 = iota
-	StatusCode400
-	StatusCode500
+	StatusCode101 StatusCode = 101
+	StatusCode200 StatusCode = 200
+	StatusCode206 StatusCode = 206
+	StatusCode301 StatusCode = 301
+	StatusCode304 StatusCode = 304
+	StatusCode400 StatusCode = 400
+	StatusCode403 StatusCode = 403
+	StatusCode404 StatusCode = 404
+	StatusCode405 StatusCode = 405
+	StatusCode406 StatusCode = 406
+	StatusCode412 StatusCode = 412
+	StatusCode413 StatusCode = 413
+	StatusCode415 StatusCode = 415
+	StatusCode416 StatusCode = 416
+	StatusCode426 StatusCode = 426
+	StatusCode500 StatusCode = 500
+	StatusCode502 StatusCode = 502
 )
 
 var (
 	ReasonPhrases = map[StatusCode]string{
+		StatusCode101: "101 Switching Protocols",
 		StatusCode200: "200 OK",
+		StatusCode206: "206 Partial Content",
+		StatusCode301: "301 Moved Permanently",
+		StatusCode304: "304 Not Modified",
 		StatusCode400: "400 Bad Request",
+		StatusCode403: "403 Forbidden",
+		StatusCode404: "404 Not Found",
+		StatusCode405: "405 Method Not Allowed",
+		StatusCode406: "406 Not Acceptable",
+		StatusCode412: "412 Precondition Failed",
+		StatusCode413: "413 Content Too Large",
+		StatusCode415: "415 Unsupported Media Type",
+		StatusCode416: "416 Range Not Satisfiable",
+		StatusCode426: "426 Upgrade Required",
 		StatusCode500: "500 Internal Server Error",
+		StatusCode502: "502 Bad Gateway",
 	}
 )
 
+// AllowsBody reports whether a response with this status can carry a body.
+func (sc StatusCode) AllowsBody() bool {
+	return sc >= 200 && sc != 204 && sc != StatusCode304
+}
+
 type WriteState int
 
 const (
@@ -34,6 +70,7 @@ const (
 	StateChunkedBody
 	StateChunkedBodyDone
 	StateTrailers
+	StateHijacked
 )
 
 type Writer struct {
@@ -42,6 +79,70 @@ type Writer struct {
 	StatusCode StatusCode
 	Headers    headers.Headers
 	Body       bytes.Buffer
+
+	counter     *countingWriter
+	headerBytes int64
+	digests     []*digest.Digest
+	compressor  *compressor
+	cookies     headers.Headers // Set-Cookie fields added by SetCookie
+	vary        []string        // field names added to Vary by Vary
+	onHeaders   []func(*Writer) error
+	conn        net.Conn      // set by SetConn for Hijack
+	buffered    func() []byte // bytes read from conn beyond the request
+}
+
+// countingWriter counts the bytes passed through to the connection.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewWriter returns a Writer for w that counts the bytes written through it,
+// so BytesWritten can report the size of the response body as sent.
+func NewWriter(w io.Writer) *Writer {
+	c := &countingWriter{w: w}
+	return &Writer{
+		Writer:  c,
+		State:   StateStatus,
+		counter: c,
+	}
+}
+
+// BytesWritten returns the number of bytes written to the connection after
+// the response headers, including any chunked framing and trailers. It is
+// always zero for a Writer not created with NewWriter.
+func (w *Writer) BytesWritten() int64 {
+	if w.counter == nil || w.State < StateBody {
+		return 0
+	}
+	return w.counter.n - w.headerBytes
+}
+
+// Digest returns a digest of the body content written through w from now on,
+// excluding any chunked framing. Send digest.Field of it as a Content-Digest
+// trailer once a chunked body is done; for a Content-Digest or Repr-Digest
+// header the body must be digested before the headers are written, e.g. with
+// digest.Of.
+func (w *Writer) Digest(alg digest.Algorithm) (*digest.Digest, error) {
+	d, err := digest.New(alg)
+	if err != nil {
+		return nil, err
+	}
+	w.digests = append(w.digests, d)
+	return d, nil
+}
+
+// digestBody feeds body content to the digests of w.
+func (w *Writer) digestBody(p []byte) {
+	for _, d := range w.digests {
+		d.Write(p)
+	}
 }
 
 func writeStatusLine(w io.Writer, statusCode StatusCode) error {
@@ -52,6 +153,8 @@ func writeStatusLine(w io.Writer, statusCode StatusCode) error {
 	r = "HTTP/1.1 "
 	if rp, ok := ReasonPhrases[statusCode]; ok {
 		r += rp
+	} else {
+		r += strconv.Itoa(int(statusCode)) + " "
 	}
 	r += "\r\n"
 	_, err = w.Write([]byte(r))
@@ -75,37 +178,81 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 }
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
-	h := make(headers.Headers)
-	h["Content-Length"] = strconv.Itoa(contentLen)
-	h["Connection"] = "close"
-	h["Content-Type"] = "text/html"
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(contentLen))
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/html")
 	return h
 }
 
+// writeHeaders writes the fields in order, one line per value, followed by the
+// blank line ending the section.
 func writeHeaders(w io.Writer, headers headers.Headers) error {
 	var (
-		err  error
-		k, v string
+		b strings.Builder
 	)
-	for k, v = range headers {
-		_, err = w.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
-		if err != nil {
-			break
-		}
+	for _, f := range headers {
+		b.WriteString(f.Name + ": " + f.Value + "\r\n")
 	}
-	_, err = w.Write([]byte("\r\n"))
+	b.WriteString("\r\n")
+	_, err := io.WriteString(w, b.String())
 	return err
 }
 
+// mergeHeaders replaces the fields of h named in extra by those of extra.
+func mergeHeaders(h headers.Headers, extra headers.Headers) headers.Headers {
+	for _, f := range extra {
+		h.Del(f.Name)
+	}
+	for _, f := range extra {
+		h.Add(f.Name, f.Value)
+	}
+	return h
+}
+
+// OnWriteHeaders registers f to be called by WriteHeaders just before the
+// headers are written, for instance to set cookies from state the handler may
+// still have changed after f was registered. An error from f is returned by
+// WriteHeaders.
+func (w *Writer) OnWriteHeaders(f func(w *Writer) error) {
+	w.onHeaders = append(w.onHeaders, f)
+}
+
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	var (
 		err error
 	)
 	if w.State == StateHeader {
+		for len(w.onHeaders) > 0 {
+			f := w.onHeaders[0]
+			w.onHeaders = w.onHeaders[1:]
+			err = f(w)
+			if err != nil {
+				return err
+			}
+		}
+		if len(w.cookies) > 0 {
+			headers = append(headers.Clone(), w.cookies...)
+		}
+		if len(w.vary) > 0 {
+			headers = headers.Clone()
+			addVary(&headers, w.vary...)
+		}
+		if w.compressor != nil {
+			// the handler's fields are left as they are
+			headers = headers.Clone()
+			err = w.startCompression(&headers)
+			if err != nil {
+				return err
+			}
+		}
 		w.Headers = headers
 		err = writeHeaders(w.Writer, headers)
 		if err == nil {
 			w.State = StateBody
+			if w.counter != nil {
+				w.headerBytes = w.counter.n
+			}
 		}
 	} else {
 		err = fmt.Errorf("Error: writing response headers out of sequence")
@@ -118,28 +265,93 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 		err error
 		n   int
 	)
-	if w.State == StateBody {
+	if w.State == StateBody && w.compressing() {
+		n, err = w.compressor.enc.Write(p)
+		if err != nil {
+			err = fmt.Errorf("Error compressing response body: %v", err)
+		}
+	} else if w.State == StateBody {
 		n, err = w.Body.Write(p)
 		if err != nil || n != len(p) {
 			err = fmt.Errorf("Error writing response body: %v\n", err)
 		}
+		w.digestBody(p[:n])
 	} else {
 		err = fmt.Errorf("Error: writing body out of sequence")
 	}
 	return n, err
 }
 
+// WriteBodyFrom streams the body from r straight to the connection instead of
+// buffering it in Body, so large bodies need not be held in memory.
+func (w *Writer) WriteBodyFrom(r io.Reader) (int64, error) {
+	var (
+		err error
+		n   int64
+	)
+	if w.State == StateBody {
+		// anything already buffered must precede the streamed bytes
+		_, err = w.Body.WriteTo(w.Writer)
+		if err == nil && w.compressing() {
+			n, err = w.compressFrom(r)
+		} else if err == nil {
+			for _, d := range w.digests {
+				r = io.TeeReader(r, d)
+			}
+			n, err = io.Copy(w.Writer, r)
+		}
+		if err != nil {
+			err = fmt.Errorf("Error streaming response body: %v", err)
+		}
+	} else {
+		err = fmt.Errorf("Error: writing body out of sequence")
+	}
+	return n, err
+}
+
+// WriteError writes a complete response for statusCode with its reason phrase
+// as a plain text body. extra headers are added to the defaults.
+func (w *Writer) WriteError(statusCode StatusCode, extra headers.Headers) error {
+	var (
+		err error
+		h   headers.Headers
+		msg string
+	)
+	msg = ReasonPhrases[statusCode]
+	if msg == "" {
+		msg = strconv.Itoa(int(statusCode))
+	}
+	msg += "\n"
+	err = w.WriteStatusLine(statusCode)
+	if err == nil {
+		h = GetDefaultHeaders(len(msg))
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		err = w.WriteHeaders(mergeHeaders(h, extra))
+		if err == nil {
+			_, err = w.WriteBody([]byte(msg))
+		}
+	}
+	return err
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	var (
 		err     error
 		n, crlf int
 	)
-	if w.State == StateChunkedBody {
+	if w.State == StateChunkedBody && w.compressing() {
+		n, err = w.compressor.enc.Write(p)
+		if err != nil {
+			err = fmt.Errorf("Error compressing response body chunk: %v", err)
+		}
+		n += 2
+	} else if w.State == StateChunkedBody {
 		w.Body.Write([]byte(fmt.Sprintf("%x\r\n", len(p))))
 		n, err = w.Body.Write(p)
 		if err != nil || n != len(p) {
 			err = fmt.Errorf("Error writing response body chunk: %v\n", err)
 		}
+		w.digestBody(p[:n])
 		crlf, err = w.Body.Write([]byte("\r\n"))
 		if err != nil || crlf != 2 {
 			err = fmt.Errorf("Error writing response body chunk: %v\n", err)
@@ -157,6 +369,11 @@ func (w *Writer) WriteChunkedBodyDone() (int64, error) {
 		n   int
 	)
 	if w.State == StateChunkedBodyDone {
+		// the rest of a compressed body precedes the last chunk
+		err = w.finishCompression()
+		if err != nil {
+			return 0, fmt.Errorf("Error compressing response body: %v", err)
+		}
 		n, err = w.Body.Write([]byte("0\r\n"))
 		if err == nil {
 			w.State = StateTrailers
@@ -172,10 +389,8 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 		err error
 	)
 	if w.State == StateTrailers {
+		// the blank line ending the trailer section also ends the message
 		err = writeHeaders(w.Writer, h)
-		if err == nil {
-			_, err = w.Body.Write([]byte("\r\n"))
-		}
 	} else {
 		err = fmt.Errorf("Error: writing response trailers out of sequence")
 	}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+type AccessLogFormat int
+
+const (
+	// CommonLogFormat: host ident authuser [date] "request-line" status bytes
+	CommonLogFormat AccessLogFormat = iota
+	// CombinedLogFormat: Common Log Format followed by "referer" "user-agent"
+	CombinedLogFormat
+	// JSONLogFormat: one JSON object per line
+	JSONLogFormat
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry describes one served request.
+type AccessLogEntry struct {
+	RemoteAddr string        `json:"remote_addr"`
+	Time       time.Time     `json:"time"`
+	Method     string        `json:"method"`
+	Target     string        `json:"target"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+}
+
+// NewAccessLogEntry builds the entry for req answered through w, which should
+// have been created with response.NewWriter for the byte count to be known.
+func NewAccessLogEntry(remoteAddr string, start time.Time, req *request.Request, w *response.Writer) AccessLogEntry {
+	return AccessLogEntry{
+		RemoteAddr: remoteAddr,
+		Time:       start,
+		Method:     req.RequestLine.Method,
+		Target:     req.RequestLine.RequestTarget,
+		Proto:      "HTTP/" + req.RequestLine.HttpVersion,
+		Status:     int(w.StatusCode),
+		Bytes:      w.BytesWritten(),
+		Duration:   time.Since(start),
+		Referer:    req.Headers.Get("Referer"),
+		UserAgent:  req.Headers.Get("User-Agent"),
+	}
+}
+
+// AccessLog writes one line per served request to Writer. It is safe for use
+// by concurrent connections.
+type AccessLog struct {
+	Format AccessLogFormat
+	Writer io.Writer
+
+	mu sync.Mutex
+}
+
+func NewAccessLog(w io.Writer, format AccessLogFormat) *AccessLog {
+	return &AccessLog{
+		Format: format,
+		Writer: w,
+	}
+}
+
+// Log formats e and writes it to the access log as a single line.
+func (a *AccessLog) Log(e AccessLogEntry) error {
+	var (
+		err  error
+		line []byte
+	)
+	switch a.Format {
+	case CommonLogFormat:
+		line = []byte(e.common() + "\n")
+	case CombinedLogFormat:
+		line = []byte(fmt.Sprintf("%s \"%s\" \"%s\"\n", e.common(), clfEscape(e.Referer), clfEscape(e.UserAgent)))
+	case JSONLogFormat:
+		line, err = json.Marshal(struct {
+			AccessLogEntry
+			DurationMs float64 `json:"duration_ms"`
+		}{e, float64(e.Duration.Microseconds()) / 1000})
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+	default:
+		return fmt.Errorf("Error: unknown access log format %d", a.Format)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.Writer.Write(line)
+	return err
+}
+
+func (e AccessLogEntry) common() string {
+	var (
+		host   string
+		err    error
+		bytes  string = "-"
+		status string = "-"
+	)
+	host, _, err = net.SplitHostPort(e.RemoteAddr)
+	if err != nil {
+		host = e.RemoteAddr
+	}
+	if host == "" {
+		host = "-"
+	}
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	if e.Status > 0 {
+		status = strconv.Itoa(e.Status)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s\" %s %s",
+		host, e.Time.Format(clfTimeFormat), clfEscape(e.Method+" "+e.Target+" "+e.Proto), status, bytes)
+}
+
+// clfEscape escapes quotes, backslashes and non-printable bytes the way Apache
+// does, so client-supplied values cannot break the line format.
+func clfEscape(s string) string {
+	var b strings.Builder
+	if s == "" {
+		return "-"
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// RotatingFile is an io.WriteCloser appending to the file at Path. Once a write
+// would grow the file beyond MaxSize bytes, the file is renamed to Path.1
+// (shifting older backups up to Path.<MaxBackups>) and a new file is started.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	var (
+		err  error
+		info os.FileInfo
+	)
+	r.f, err = os.OpenFile(r.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err = r.f.Stat()
+	if err != nil {
+		r.f.Close()
+		return err
+	}
+	r.size = info.Size()
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	var err error
+	err = r.f.Close()
+	if err != nil {
+		return err
+	}
+	if r.MaxBackups < 1 {
+		err = os.Remove(r.Path)
+	} else {
+		for i := r.MaxBackups - 1; i > 0; i-- {
+			err = os.Rename(fmt.Sprintf("%s.%d", r.Path, i), fmt.Sprintf("%s.%d", r.Path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(r.Path, r.Path+".1")
+	}
+	if err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	var (
+		err error
+		n   int
+	)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		err = r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe to read while a connection goroutine writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func testEntry() AccessLogEntry {
+	return AccessLogEntry{
+		RemoteAddr: "127.0.0.1:51234",
+		Time:       time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+		Method:     "GET",
+		Target:     "/apache_pb.gif",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      2326,
+		Duration:   1500 * time.Microsecond,
+		Referer:    "http://www.example.com/start.html",
+		UserAgent:  "Mozilla/4.08 \"quoted\"",
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		e   AccessLogEntry
+		err error
+	)
+	e = testEntry()
+
+	// Test: Common Log Format
+	err = NewAccessLog(&buf, CommonLogFormat).Log(e)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.1\" 200 2326\n", buf.String())
+
+	// Test: Combined Log Format escapes quotes in client supplied fields
+	buf.Reset()
+	err = NewAccessLog(&buf, CombinedLogFormat).Log(e)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.1\" 200 2326 \"http://www.example.com/start.html\" \"Mozilla/4.08 \\\"quoted\\\"\"\n", buf.String())
+
+	// Test: Missing values are logged as "-"
+	buf.Reset()
+	e.Bytes = 0
+	e.Referer = ""
+	err = NewAccessLog(&buf, CombinedLogFormat).Log(e)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "\" 200 - \"-\" ")
+
+	// Test: JSON
+	buf.Reset()
+	err = NewAccessLog(&buf, JSONLogFormat).Log(testEntry())
+	require.NoError(t, err)
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &m))
+	assert.Equal(t, "GET", m["method"])
+	assert.Equal(t, "/apache_pb.gif", m["target"])
+	assert.Equal(t, float64(200), m["status"])
+	assert.Equal(t, float64(2326), m["bytes"])
+	assert.Equal(t, 1.5, m["duration_ms"])
+	assert.Equal(t, "127.0.0.1:51234", m["remote_addr"])
+}
+
+func TestAccessLogServer(t *testing.T) {
+	var (
+		buf syncBuffer
+		c   net.Conn
+		err error
+		s   *Server
+	)
+	s, err = Serve(0, echoHandler, WithAccessLog(NewAccessLog(&buf, CommonLogFormat)))
+	require.NoError(t, err)
+	defer s.Close()
+	c, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	// Test: Served requests are logged with status and body size
+	_, err = c.Write([]byte("POST /second HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "/second hello", readBody(t, bufio.NewReader(c)))
+	assert.Eventually(t, func() bool { return buf.String() != "" }, time.Second, 5*time.Millisecond)
+	assert.Regexp(t, `^\S+ - - \[[^]]+\] "POST /second HTTP/1\.1" 200 13\n$`, buf.String())
+}
+
+func TestRotatingFile(t *testing.T) {
+	var (
+		data []byte
+		err  error
+		path string
+		r    *RotatingFile
+	)
+	path = filepath.Join(t.TempDir(), "access.log")
+	r, err = NewRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer r.Close()
+
+	// Test: Writes that would exceed the size limit start a new file
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = r.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "fourth\n", string(data))
+	data, err = os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "third\n", string(data))
+	data, err = os.ReadFile(path + ".2")
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(data))
+
+	// Test: Backups beyond MaxBackups are discarded
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+// number of bytes examined to detect a content type without a known extension
+const sniffLen = 512
+
+// FileServer serves the files below the directory Root.
+type FileServer struct {
+	Root string
+	// Prefix is stripped from the request target before it is mapped to a file.
+	Prefix string
+	// IndexFiles are served in place of a directory, in order of preference.
+	IndexFiles []string
+	// Listing enables HTML listings of directories without an index file.
+	Listing bool
+}
+
+func NewFileServer(root string) *FileServer {
+	return &FileServer{
+		Root:       root,
+		IndexFiles: []string{"index.html"},
+	}
+}
+
+// resolve maps a request target to a slash-separated path below Root. The
+// result is rooted and cleaned, so it cannot refer to a parent of Root.
+func (fs *FileServer) resolve(target string) (string, error) {
+	var (
+		err  error
+		name string
+	)
+	name, _, _ = strings.Cut(target, "?")
+	if !strings.HasPrefix(name, fs.Prefix) {
+		return "", os.ErrNotExist
+	}
+	name, err = url.PathUnescape(strings.TrimPrefix(name, fs.Prefix))
+	if err != nil {
+		return "", os.ErrNotExist
+	}
+	if strings.ContainsAny(name, "\x00\\") {
+		return "", os.ErrNotExist
+	}
+	trailing := strings.HasSuffix(name, "/")
+	name = path.Clean("/" + name)
+	if trailing && name != "/" {
+		name += "/"
+	}
+	return name, nil
+}
+
+// open opens name below Root, refusing symbolic links that lead outside it.
+func (fs *FileServer) open(name string) (*os.File, os.FileInfo, error) {
+	var (
+		err        error
+		f          *os.File
+		full, real string
+		info       os.FileInfo
+		root       string
+	)
+	full = filepath.Join(fs.Root, filepath.FromSlash(name))
+	root, err = filepath.EvalSymlinks(fs.Root)
+	if err != nil {
+		return nil, nil, err
+	}
+	real, err = filepath.EvalSymlinks(full)
+	if err != nil {
+		return nil, nil, err
+	}
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return nil, nil, os.ErrPermission
+	}
+	f, err = os.Open(real)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+func statusForError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return response.StatusCode404
+	case errors.Is(err, os.ErrPermission):
+		return response.StatusCode403
+	}
+	return response.StatusCode500
+}
+
+// Handler serves the file named by the request target.
+func (fs *FileServer) Handler(w *response.Writer, req *request.Request) error {
+	var (
+		err  error
+		name string
+	)
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		return w.WriteError(response.StatusCode405, headers.Headers{{Name: "Allow", Value: "GET, HEAD"}})
+	}
+	name, err = fs.resolve(req.RequestLine.RequestTarget)
+	if err != nil {
+		return w.WriteError(statusForError(err), nil)
+	}
+	return fs.ServeFile(w, req, name)
+}
+
+// ServeFile serves name, a slash-separated path relative to Root, whatever the
+// request target is.
+func (fs *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) error {
+	var (
+		err  error
+		f    *os.File
+		info os.FileInfo
+	)
+	f, info, err = fs.open(name)
+	if err != nil {
+		return w.WriteError(statusForError(err), nil)
+	}
+	defer f.Close()
+	if !info.IsDir() {
+		return ServeContent(w, req, info.Name(), info.ModTime(), etag(info.ModTime(), info.Size()), f)
+	}
+
+	// directories are only served with a trailing slash so relative links work
+	target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if !strings.HasSuffix(target, "/") {
+		return w.WriteError(response.StatusCode301, headers.Headers{{Name: "Location", Value: target + "/"}})
+	}
+	for _, index := range fs.IndexFiles {
+		idx, idxInfo, err := fs.open(path.Join(name, index))
+		if err != nil {
+			continue
+		}
+		defer idx.Close()
+		if idxInfo.IsDir() {
+			continue
+		}
+		return ServeContent(w, req, idxInfo.Name(), idxInfo.ModTime(), etag(idxInfo.ModTime(), idxInfo.Size()), idx)
+	}
+	if !fs.Listing {
+		return w.WriteError(response.StatusCode403, nil)
+	}
+	return serveListing(w, req, f)
+}
+
+// contentType detects the media type of content from the extension of name,
+// falling back to sniffing its first bytes.
+func contentType(name string, content io.ReadSeeker) (string, error) {
+	var (
+		buf []byte
+		ct  string
+		err error
+		n   int
+	)
+	ct = mime.TypeByExtension(filepath.Ext(name))
+	if ct != "" {
+		return ct, nil
+	}
+	buf = make([]byte, sniffLen)
+	n, err = io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// etag derives a validator from a file's modification time and size.
+func etag(modtime time.Time, size int64) string {
+	return fmt.Sprintf("\"%x-%x\"", modtime.UnixNano(), size)
+}
+
+// ServeContent writes the representation read from content, honouring the
+// conditional request headers, Range and If-Range. name is used to detect the
+// content type when it has a known extension; modtime and etag are sent as
+// validators unless zero or empty.
+func ServeContent(w *response.Writer, req *request.Request, name string, modtime time.Time, etag string, content io.ReadSeeker) error {
+	var (
+		ct     string
+		err    error
+		h      headers.Headers
+		ranges []ByteRange
+		size   int64
+	)
+	done, err := w.WritePreconditions(req.RequestLine.Method, req.Headers, etag, modtime)
+	if done || err != nil {
+		return err
+	}
+	size, err = content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	ct, err = contentType(name, content)
+	if err != nil {
+		return err
+	}
+	h = response.GetDefaultHeaders(0)
+	h.Set("Accept-Ranges", "bytes")
+	if !modtime.IsZero() {
+		h.Set("Last-Modified", headers.FormatTime(modtime))
+	}
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+
+	if req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD" {
+		ranges, err = rangesFor(req, size, modtime, etag)
+		if err == ErrUnsatisfiableRange {
+			return w.WriteError(response.StatusCode416, headers.Headers{
+				{Name: "Accept-Ranges", Value: "bytes"},
+				{Name: "Content-Range", Value: fmt.Sprintf("bytes */%d", size)},
+			})
+		}
+	}
+	switch len(ranges) {
+	case 0:
+		err = w.WriteStatusLine(response.StatusCode200)
+		if err != nil {
+			return err
+		}
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		h.Set("Content-Type", ct)
+		err = w.WriteHeaders(h)
+		if err != nil || req.RequestLine.Method == "HEAD" {
+			return err
+		}
+		_, err = w.WriteBodyFrom(io.LimitReader(content, size))
+		return err
+	case 1:
+		return serveRange(w, req, h, ct, size, ranges[0], content)
+	}
+	return serveMultipartRanges(w, req, h, ct, size, ranges, content)
+}
+
+// serveListing writes an HTML index of the entries of dir.
+func serveListing(w *response.Writer, req *request.Request, dir *os.File) error {
+	var (
+		b       strings.Builder
+		entries []os.DirEntry
+		err     error
+		h       headers.Headers
+	)
+	entries, err = dir.ReadDir(-1)
+	if err != nil {
+		return w.WriteError(response.StatusCode500, nil)
+	}
+	slices.SortFunc(entries, func(a, b os.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	fmt.Fprintf(&b, "<html><head><title>Index of %[1]s</title></head><body><h1>Index of %[1]s</h1><ul>\n", html.EscapeString(target))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString("./"+(&url.URL{Path: name}).EscapedPath()), html.EscapeString(name))
+	}
+	b.WriteString("</ul></body></html>\n")
+
+	err = w.WriteStatusLine(response.StatusCode200)
+	if err == nil {
+		h = response.GetDefaultHeaders(b.Len())
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		err = w.WriteHeaders(h)
+		if err == nil && req.RequestLine.Method != "HEAD" {
+			_, err = w.WriteBody([]byte(b.String()))
+		}
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFileServer(t *testing.T) *FileServer {
+	t.Helper()
+	var (
+		dir  string
+		root string
+	)
+	dir = t.TempDir()
+	root = filepath.Join(dir, "public")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "site"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("top secret"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello world\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "page"), []byte("<!DOCTYPE html><html><body>hi</body></html>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a b.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<h1>index</h1>"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")))
+	fs := NewFileServer(root)
+	fs.Prefix = "/static"
+	return fs
+}
+
+func TestFileServer(t *testing.T) {
+	var (
+		body string
+		fs   *FileServer
+		res  *http.Response
+	)
+	fs = testFileServer(t)
+
+	// Test: File served with type from extension and validators
+	res, body = serveRaw(t, fs.Handler, "GET /static/hello.txt HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "hello world\n", body)
+	assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(t, "12", res.Header.Get("Content-Length"))
+	assert.NotEmpty(t, res.Header.Get("Last-Modified"))
+	assert.Regexp(t, `^"[0-9a-f]+-c"$`, res.Header.Get("ETag"))
+
+	// Test: Content type sniffed without an extension
+	res, _ = serveRaw(t, fs.Handler, "GET /static/page HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+
+	// Test: HEAD sends headers only
+	res, body = serveRaw(t, fs.Handler, "HEAD /static/hello.txt HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "12", res.Header.Get("Content-Length"))
+	assert.Equal(t, "", body)
+
+	// Test: Percent-encoded names
+	res, body = serveRaw(t, fs.Handler, "GET /static/docs/a%20b.txt HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "a", body)
+
+	// Test: Missing file and unsupported method
+	res, _ = serveRaw(t, fs.Handler, "GET /static/missing.txt HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = serveRaw(t, fs.Handler, "POST /static/hello.txt HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "GET, HEAD", res.Header.Get("Allow"))
+}
+
+func TestFileServerTraversal(t *testing.T) {
+	var (
+		body string
+		fs   *FileServer
+		res  *http.Response
+	)
+	fs = testFileServer(t)
+
+	// Test: Parent directory references cannot escape the root
+	for _, target := range []string{
+		"/static/../secret.txt",
+		"/static/%2e%2e/secret.txt",
+		"/static/docs/../../secret.txt",
+		"/static/..%2fsecret.txt",
+		"/static/..%5csecret.txt",
+	} {
+		res, body = serveRaw(t, fs.Handler, "GET "+target+" HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+		assert.Equal(t, http.StatusNotFound, res.StatusCode, target)
+		assert.NotContains(t, body, "top secret", target)
+	}
+
+	// Test: Symbolic links leading outside the root are refused
+	res, body = serveRaw(t, fs.Handler, "GET /static/link.txt HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.NotContains(t, body, "top secret")
+}
+
+func TestFileServerDirectories(t *testing.T) {
+	var (
+		body string
+		fs   *FileServer
+		res  *http.Response
+	)
+	fs = testFileServer(t)
+
+	// Test: Directories without a trailing slash are redirected
+	res, _ = serveRaw(t, fs.Handler, "GET /static/site HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusMovedPermanently, res.StatusCode)
+	assert.Equal(t, "/static/site/", res.Header.Get("Location"))
+
+	// Test: Index file served for a directory
+	res, body = serveRaw(t, fs.Handler, "GET /static/site/ HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "<h1>index</h1>", body)
+
+	// Test: Listings are disabled by default
+	res, _ = serveRaw(t, fs.Handler, "GET /static/docs/ HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// Test: Listing with escaped links
+	fs.Listing = true
+	res, body = serveRaw(t, fs.Handler, "GET /static/docs/ HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, body, `<a href="./a%20b.txt">a b.txt</a>`)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+// DecodeJSON decodes the JSON request body into v, answering requests whose
+// body cannot be decoded itself with an application/problem+json response:
+// 415 for bodies not declared as JSON, 413 for bodies over opts.MaxSize and 400
+// for anything else. It reports whether it wrote a response, in which case the
+// handler should return the error without writing another.
+//
+//	var item Item
+//	if done, err := server.DecodeJSON(w, req, &item, request.DefaultJSONOptions); done {
+//		return err
+//	}
+func DecodeJSON(w *response.Writer, req *request.Request, v any, opts request.JSONOptions) (bool, error) {
+	var (
+		extra headers.Headers
+	)
+	err := req.DecodeJSON(v, opts)
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.Is(err, request.ErrNotJSON):
+		// tell the client which media type to send instead
+		switch req.RequestLine.Method {
+		case "POST":
+			extra.Set("Accept-Post", "application/json")
+		case "PATCH":
+			extra.Set("Accept-Patch", "application/json")
+		}
+		return true, w.WriteProblem(response.Problem{Status: response.StatusCode415, Detail: "The request body must be JSON."}, extra)
+	case errors.Is(err, request.ErrJSONTooLarge):
+		return true, w.WriteProblem(response.Problem{Status: response.StatusCode413, Detail: err.Error()}, nil)
+	}
+	return true, w.WriteProblem(response.Problem{Status: response.StatusCode400, Detail: err.Error()}, nil)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+// itemHandler answers with the name of the item in the JSON request body.
+func itemHandler(w *response.Writer, req *request.Request) error {
+	var item struct {
+		Name string `json:"name"`
+	}
+	if done, err := DecodeJSON(w, req, &item, request.JSONOptions{MaxSize: 32}); done {
+		return err
+	}
+	return w.WriteJSON(response.StatusCode200, map[string]string{"created": item.Name}, nil)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	send := func(method, contentType, body string) (int, string, string) {
+		raw := method + " /items HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: " + contentType + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+		res, resBody := serveRaw(t, itemHandler, raw)
+		return res.StatusCode, res.Header.Get("Content-Type"), resBody
+	}
+
+	// Test: Decoded bodies reach the handler
+	status, ct, body := send("POST", "application/json", `{"name": "pen"}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "application/json", ct)
+	assert.JSONEq(t, `{"created": "pen"}`, body)
+
+	// Test: Other media types are refused with 415
+	status, ct, body = send("POST", "text/plain", `{"name": "pen"}`)
+	assert.Equal(t, 415, status)
+	assert.Equal(t, "application/problem+json", ct)
+	assert.JSONEq(t, `{"title": "Unsupported Media Type", "status": 415, "detail": "The request body must be JSON."}`, body)
+	res, _ := serveRaw(t, itemHandler, "PATCH /items HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 2\r\n\r\n{}")
+	assert.Equal(t, "application/json", res.Header.Get("Accept-Patch"))
+
+	// Test: Oversized and invalid bodies
+	status, _, _ = send("POST", "application/json", `{"name": "a rather long fountain pen"}`)
+	assert.Equal(t, 413, status)
+	status, ct, body = send("PUT", "application/json", `{"nam": "pen"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "application/problem+json", ct)
+	assert.Contains(t, body, `unknown field \"nam\"`)
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request
+// latency histogram buckets.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// methods not in this list are counted under "OTHER" so that clients cannot
+// create an unbounded number of series
+var knownMethods = []string{"CONNECT", "DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT", "TRACE"}
+
+type requestKey struct {
+	method string
+	status int
+}
+
+type histogram struct {
+	buckets []float64 // Metrics.Buckets when the histogram was created
+	counts  []uint64  // per bucket, not cumulative
+	count   uint64
+	sum     float64
+}
+
+// Metrics collects server instrumentation and serves it in the Prometheus text
+// exposition format. It is safe for use by concurrent connections.
+type Metrics struct {
+	// Buckets are the upper bounds of the latency histograms. A histogram
+	// keeps the buckets set when its method is first observed.
+	Buckets []float64
+
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	active      atomic.Int64
+	connections atomic.Uint64
+
+	mu          sync.Mutex
+	requests    map[requestKey]uint64
+	latency     map[string]*histogram
+	parseErrors map[string]uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Buckets:     DefaultLatencyBuckets,
+		requests:    make(map[requestKey]uint64),
+		latency:     make(map[string]*histogram),
+		parseErrors: make(map[string]uint64),
+	}
+}
+
+// WithMetrics instruments the server's connections and requests in m.
+func WithMetrics(m *Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+func methodLabel(method string) string {
+	if slices.Contains(knownMethods, method) {
+		return method
+	}
+	return "OTHER"
+}
+
+// ObserveRequest records a served request and how long it took.
+func (m *Metrics) ObserveRequest(method string, status int, d time.Duration) {
+	var (
+		h *histogram
+		i int
+		v float64 = d.Seconds()
+	)
+	method = methodLabel(method)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{method, status}]++
+	h = m.latency[method]
+	if h == nil {
+		h = &histogram{buckets: slices.Clone(m.Buckets), counts: make([]uint64, len(m.Buckets))}
+		m.latency[method] = h
+	}
+	for i = range h.buckets {
+		if v <= h.buckets[i] {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// parseErrorKind classifies an error returned by request.Reader.
+func parseErrorKind(err error) string {
+	switch {
+	case errors.Is(err, request.ErrMalformedRequestLine):
+		return "request_line"
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return "version"
+	case errors.Is(err, headers.ErrMalformedHeader), errors.Is(err, headers.ErrMissingHeaders):
+		return "header"
+	case errors.Is(err, request.ErrInvalidContentLength):
+		return "content_length"
+	case errors.Is(err, request.ErrBodyTooShort), errors.Is(err, io.ErrUnexpectedEOF):
+		return "truncated"
+	case errors.Is(err, net.ErrClosed), errors.Is(err, io.EOF):
+		return "connection"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return "connection"
+	}
+	return "other"
+}
+
+// ObserveParseError records a request that could not be parsed.
+func (m *Metrics) ObserveParseError(err error) {
+	kind := parseErrorKind(err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parseErrors[kind]++
+}
+
+// metricsConn counts the bytes read from and written to a connection, and
+// stops counting it as active once it is closed.
+type metricsConn struct {
+	net.Conn
+	m      *Metrics
+	closed sync.Once
+}
+
+func (c *metricsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.m.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c *metricsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.m.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+func (c *metricsConn) Close() error {
+	c.closed.Do(func() { c.m.active.Add(-1) })
+	return c.Conn.Close()
+}
+
+// instrument returns a wrapper counting the traffic of c, which is counted as
+// an active connection until the wrapper is closed - by the server, or by the
+// handler that hijacked it.
+func (m *Metrics) instrument(c net.Conn) net.Conn {
+	m.connections.Add(1)
+	m.active.Add(1)
+	return &metricsConn{Conn: c, m: m}
+}
+
+// labelEscape escapes a label value as required by the exposition format.
+func labelEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var (
+		b       strings.Builder
+		cum     uint64
+		keys    []requestKey
+		methods []string
+		kinds   []string
+	)
+	m.mu.Lock()
+	b.WriteString("# HELP http_requests_total Total number of HTTP requests served.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if c := strings.Compare(a.method, b.method); c != 0 {
+			return c
+		}
+		return a.status - b.status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "http_requests_total{method=\"%s\",status=\"%d\"} %d\n", labelEscape(k.method), k.status, m.requests[k])
+	}
+
+	b.WriteString("# HELP http_request_duration_seconds Time taken to handle HTTP requests.\n")
+	b.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for k := range m.latency {
+		methods = append(methods, k)
+	}
+	slices.Sort(methods)
+	for _, method := range methods {
+		h := m.latency[method]
+		cum = 0
+		for i, le := range h.buckets {
+			cum += h.counts[i]
+			fmt.Fprintf(&b, "http_request_duration_seconds_bucket{method=\"%s\",le=\"%s\"} %d\n", labelEscape(method), formatFloat(le), cum)
+		}
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{method=\"%s\",le=\"+Inf\"} %d\n", labelEscape(method), h.count)
+		fmt.Fprintf(&b, "http_request_duration_seconds_sum{method=\"%s\"} %s\n", labelEscape(method), formatFloat(h.sum))
+		fmt.Fprintf(&b, "http_request_duration_seconds_count{method=\"%s\"} %d\n", labelEscape(method), h.count)
+	}
+
+	b.WriteString("# HELP http_parse_errors_total Total number of requests that could not be parsed.\n")
+	b.WriteString("# TYPE http_parse_errors_total counter\n")
+	for k := range m.parseErrors {
+		kinds = append(kinds, k)
+	}
+	slices.Sort(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "http_parse_errors_total{kind=\"%s\"} %d\n", labelEscape(kind), m.parseErrors[kind])
+	}
+	m.mu.Unlock()
+
+	b.WriteString("# HELP http_received_bytes_total Total number of bytes read from connections.\n")
+	b.WriteString("# TYPE http_received_bytes_total counter\n")
+	fmt.Fprintf(&b, "http_received_bytes_total %d\n", m.bytesIn.Load())
+	b.WriteString("# HELP http_sent_bytes_total Total number of bytes written to connections.\n")
+	b.WriteString("# TYPE http_sent_bytes_total counter\n")
+	fmt.Fprintf(&b, "http_sent_bytes_total %d\n", m.bytesOut.Load())
+	b.WriteString("# HELP http_connections_total Total number of accepted connections.\n")
+	b.WriteString("# TYPE http_connections_total counter\n")
+	fmt.Fprintf(&b, "http_connections_total %d\n", m.connections.Load())
+	b.WriteString("# HELP http_active_connections Number of currently open connections.\n")
+	b.WriteString("# TYPE http_active_connections gauge\n")
+	fmt.Fprintf(&b, "http_active_connections %d\n", m.active.Load())
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Handler serves the metrics. It can be called from any route of a Handler.
+func (m *Metrics) Handler(w *response.Writer, req *request.Request) error {
+	var (
+		body strings.Builder
+		err  error
+		h    headers.Headers
+	)
+	_, err = m.WriteTo(&body)
+	if err != nil {
+		return err
+	}
+	err = w.WriteStatusLine(response.StatusCode200)
+	if err == nil {
+		h = response.GetDefaultHeaders(body.Len())
+		h.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err = w.WriteHeaders(h)
+		if err == nil {
+			_, err = w.WriteBody([]byte(body.String()))
+		}
+	}
+	return err
+}
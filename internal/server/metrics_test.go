@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	var (
+		b   strings.Builder
+		err error
+		m   *Metrics
+	)
+	m = NewMetrics()
+	m.Buckets = []float64{0.1, 1}
+	m.ObserveRequest("GET", 200, 50*time.Millisecond)
+	m.ObserveRequest("GET", 200, 500*time.Millisecond)
+	m.ObserveRequest("GET", 404, 2*time.Second)
+	m.ObserveRequest("BREW", 400, time.Millisecond)
+	m.ObserveParseError(request.ErrUnsupportedVersion)
+
+	_, err = m.WriteTo(&b)
+	require.NoError(t, err)
+	out := b.String()
+
+	// Test: Request counts by method and status, unknown methods grouped
+	assert.Contains(t, out, "# TYPE http_requests_total counter\n")
+	assert.Contains(t, out, "http_requests_total{method=\"GET\",status=\"200\"} 2\n")
+	assert.Contains(t, out, "http_requests_total{method=\"GET\",status=\"404\"} 1\n")
+	assert.Contains(t, out, "http_requests_total{method=\"OTHER\",status=\"400\"} 1\n")
+	assert.NotContains(t, out, "BREW")
+
+	// Test: Cumulative latency histogram
+	assert.Contains(t, out, "# TYPE http_request_duration_seconds histogram\n")
+	assert.Contains(t, out, "http_request_duration_seconds_bucket{method=\"GET\",le=\"0.1\"} 1\n")
+	assert.Contains(t, out, "http_request_duration_seconds_bucket{method=\"GET\",le=\"1\"} 2\n")
+	assert.Contains(t, out, "http_request_duration_seconds_bucket{method=\"GET\",le=\"+Inf\"} 3\n")
+	assert.Contains(t, out, "http_request_duration_seconds_sum{method=\"GET\"} 2.55\n")
+	assert.Contains(t, out, "http_request_duration_seconds_count{method=\"GET\"} 3\n")
+
+	// Test: Parse errors by kind
+	assert.Contains(t, out, "http_parse_errors_total{kind=\"version\"} 1\n")
+
+	// Test: Changed buckets apply to methods observed for the first time
+	m.Buckets = []float64{0.01, 0.1, 1, 10}
+	m.ObserveRequest("GET", 200, 5*time.Second)
+	m.ObserveRequest("POST", 200, 5*time.Second)
+	b.Reset()
+	_, err = m.WriteTo(&b)
+	require.NoError(t, err)
+	out = b.String()
+	assert.Contains(t, out, "http_request_duration_seconds_bucket{method=\"GET\",le=\"+Inf\"} 4\n")
+	assert.NotContains(t, out, "http_request_duration_seconds_bucket{method=\"GET\",le=\"10\"}")
+	assert.Contains(t, out, "http_request_duration_seconds_bucket{method=\"POST\",le=\"10\"} 1\n")
+}
+
+func TestMetricsServer(t *testing.T) {
+	var (
+		c   net.Conn
+		err error
+		m   *Metrics
+		s   *Server
+	)
+	m = NewMetrics()
+	handler := func(w *response.Writer, req *request.Request) error {
+		if req.RequestLine.RequestTarget == "/metrics" {
+			return m.Handler(w, req)
+		}
+		return echoHandler(w, req)
+	}
+	s, err = Serve(0, handler, WithMetrics(m))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: A malformed request is counted as a parse error
+	c, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	_, err = c.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	// wait for the server to drop the connection
+	io.ReadAll(c)
+	c.Close()
+
+	c, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	_, err = c.Write([]byte("POST /echo HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	readBody(t, rd)
+
+	// Test: Metrics are served in the text exposition format
+	_, err = c.Write([]byte("GET /metrics HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	out := readBody(t, rd)
+	assert.Contains(t, out, "http_requests_total{method=\"POST\",status=\"200\"} 1\n")
+	assert.Contains(t, out, "http_request_duration_seconds_count{method=\"POST\"} 1\n")
+	assert.Contains(t, out, "http_parse_errors_total{kind=\"version\"} 1\n")
+	assert.Contains(t, out, "http_connections_total 2\n")
+	assert.Contains(t, out, "http_active_connections 1\n")
+	assert.NotContains(t, out, "http_received_bytes_total 0\n")
+	assert.NotContains(t, out, "http_sent_bytes_total 0\n")
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+// Negotiate selects the media type to answer req with from the offers the
+// handler can produce, as response.NegotiateContentType does with the Accept
+// field, and adds Accept to the Vary field of the response. If no offer is
+// acceptable it answers 406 itself and returns "", in which case the handler
+// should return the error without writing another response.
+//
+//	switch ct, err := server.Negotiate(w, req, "text/html", "application/json"); ct {
+//	case "":
+//		return err
+//	case "application/json":
+//		...
+//	}
+func Negotiate(w *response.Writer, req *request.Request, offers ...string) (string, error) {
+	w.Vary("Accept")
+	ct := response.NegotiateContentType(req.Headers.Get("Accept"), offers...)
+	if ct == "" {
+		return "", w.WriteError(response.StatusCode406, nil)
+	}
+	return ct, nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+// greetingHandler answers in HTML, JSON or plain text.
+func greetingHandler(w *response.Writer, req *request.Request) error {
+	ct, err := Negotiate(w, req, "text/html", "application/json", "text/plain")
+	switch ct {
+	case "":
+		return err
+	case "application/json":
+		return w.WriteJSON(response.StatusCode200, map[string]string{"greeting": "hello"}, nil)
+	}
+	body := "hello"
+	if ct == "text/html" {
+		body = "<p>hello</p>"
+	}
+	err = w.WriteStatusLine(response.StatusCode200)
+	if err == nil {
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Content-Type", ct)
+		err = w.WriteHeaders(h)
+	}
+	if err == nil {
+		_, err = w.WriteBody([]byte(body))
+	}
+	return err
+}
+
+func TestNegotiate(t *testing.T) {
+	send := func(accept string) (int, string, string, string) {
+		raw := "GET /greeting HTTP/1.1\r\nHost: localhost:42069\r\n"
+		if accept != "" {
+			raw += "Accept: " + accept + "\r\n"
+		}
+		res, body := serveRaw(t, greetingHandler, raw+"\r\n")
+		return res.StatusCode, res.Header.Get("Content-Type"), res.Header.Get("Vary"), body
+	}
+
+	// Test: The best offer is served with Vary: Accept
+	status, ct, vary, body := send("")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "text/html", ct)
+	assert.Equal(t, "Accept", vary)
+	assert.Equal(t, "<p>hello</p>", body)
+	_, ct, _, body = send("application/json;q=0.9, text/plain;q=0.5")
+	assert.Equal(t, "application/json", ct)
+	assert.JSONEq(t, `{"greeting": "hello"}`, body)
+	_, ct, _, _ = send("text/*;q=0.5, text/html;q=0.1")
+	assert.Equal(t, "text/plain", ct)
+
+	// Test: 406 when nothing is acceptable
+	status, _, vary, _ = send("image/webp, image/*;q=0.8")
+	assert.Equal(t, 406, status)
+	assert.Equal(t, "Accept", vary)
+}
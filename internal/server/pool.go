@@ -0,0 +1,315 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+)
+
+type Strategy int
+
+const (
+	// RoundRobin sends requests to each healthy backend in turn.
+	RoundRobin Strategy = iota
+	// LeastConnections sends requests to the healthy backend with the fewest
+	// requests in flight.
+	LeastConnections
+	// ConsistentHash sends requests carrying the same value of the pool's
+	// HashHeader to the same backend for as long as it stays healthy.
+	ConsistentHash
+)
+
+// virtual nodes per backend on the consistent hash ring
+const hashReplicas = 100
+
+// ringHash places s on the consistent hash ring. Keys and backend URLs tend to
+// differ in a few trailing characters only, which FNV and similar hashes
+// leave clustered on the ring.
+func ringHash(s string) uint32 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Backend is one upstream server of a Pool.
+type Backend struct {
+	URL *url.URL
+
+	healthy  atomic.Bool
+	active   atomic.Int64
+	failures atomic.Int32
+	ejected  atomic.Int64 // unix nanoseconds of passive ejection, zero if not ejected
+}
+
+// Healthy reports whether the backend currently receives requests.
+func (b *Backend) Healthy() bool {
+	return b.healthy.Load()
+}
+
+// Active returns the number of requests in flight to the backend.
+func (b *Backend) Active() int64 {
+	return b.active.Load()
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *Backend
+}
+
+// Pool spreads requests over several backends. Backends failing MaxFailures
+// consecutive requests are ejected until an active health check succeeds or,
+// while health checks are not running, until EjectTime has passed. Set a pool
+// as the Pool of a ReverseProxy to use it.
+type Pool struct {
+	// Backends may be changed while the pool is in use, though not
+	// concurrently with Pick.
+	Backends []*Backend
+	Strategy Strategy
+	// HashHeader names the request field hashed by ConsistentHash. Requests
+	// without it are distributed round-robin.
+	HashHeader string
+	// MaxFailures is the number of consecutive failures ejecting a backend.
+	MaxFailures int
+	// EjectTime is how long an ejected backend is skipped when no health
+	// checks are running.
+	EjectTime time.Duration
+	// Retries is the number of other backends tried when an idempotent request
+	// cannot be delivered.
+	Retries int
+	// HealthCheckPath is requested from every backend each HealthCheckInterval
+	// once Start is called; 2xx and 3xx responses mark it healthy.
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	// Transport performs health checks. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	next     atomic.Uint64
+	ringMu   sync.Mutex
+	ring     []ringPoint
+	ringFor  []*Backend // the Backends ring was built from
+	stop     chan struct{}
+	checking atomic.Bool // health checks are running
+	done     sync.WaitGroup
+}
+
+func NewPool(strategy Strategy, upstreams ...string) (*Pool, error) {
+	p := &Pool{
+		Strategy:            strategy,
+		MaxFailures:         3,
+		EjectTime:           30 * time.Second,
+		Retries:             1,
+		HealthCheckInterval: 10 * time.Second,
+		HealthCheckTimeout:  2 * time.Second,
+	}
+	for _, upstream := range upstreams {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		b := &Backend{URL: u}
+		b.healthy.Store(true)
+		p.Backends = append(p.Backends, b)
+	}
+	return p, nil
+}
+
+// available reports whether b may be picked, re-admitting passively ejected
+// backends once their ejection has expired.
+func (p *Pool) available(b *Backend, exclude []*Backend) bool {
+	if slices.Contains(exclude, b) {
+		return false
+	}
+	if !b.healthy.Load() {
+		ejected := b.ejected.Load()
+		if ejected == 0 || p.checking.Load() || time.Since(time.Unix(0, ejected)) < p.EjectTime {
+			return false
+		}
+		// give the backend another chance - a further failure ejects it again
+		b.ejected.Store(0)
+		b.failures.Store(0)
+		b.healthy.Store(true)
+	}
+	return true
+}
+
+// hashRing returns the consistent hash ring of the backends, rebuilding it if
+// Backends has changed since it was built.
+func (p *Pool) hashRing() []ringPoint {
+	p.ringMu.Lock()
+	defer p.ringMu.Unlock()
+	if p.ring != nil && slices.Equal(p.ringFor, p.Backends) {
+		return p.ring
+	}
+	ring := make([]ringPoint, 0, len(p.Backends)*hashReplicas)
+	for _, b := range p.Backends {
+		for i := range hashReplicas {
+			ring = append(ring, ringPoint{ringHash(b.URL.String() + "#" + strconv.Itoa(i)), b})
+		}
+	}
+	slices.SortFunc(ring, func(a, b ringPoint) int {
+		if a.hash < b.hash {
+			return -1
+		}
+		if a.hash > b.hash {
+			return 1
+		}
+		return 0
+	})
+	p.ring = ring
+	p.ringFor = slices.Clone(p.Backends)
+	return ring
+}
+
+// Pick chooses the backend for req, skipping unhealthy backends and those in
+// exclude. It returns nil if none is available.
+func (p *Pool) Pick(req *request.Request, exclude []*Backend) *Backend {
+	var (
+		best  *Backend
+		key   string
+		n     int = len(p.Backends)
+		start int
+	)
+	if n == 0 {
+		return nil
+	}
+	if p.Strategy == ConsistentHash && p.HashHeader != "" {
+		key = req.Headers.Get(p.HashHeader)
+	}
+	if key != "" {
+		ring := p.hashRing()
+		sum := ringHash(key)
+		i, _ := slices.BinarySearchFunc(ring, sum, func(pt ringPoint, t uint32) int {
+			if pt.hash < t {
+				return -1
+			}
+			if pt.hash > t {
+				return 1
+			}
+			return 0
+		})
+		for j := range ring {
+			pt := ring[(i+j)%len(ring)]
+			if p.available(pt.backend, exclude) {
+				return pt.backend
+			}
+		}
+		return nil
+	}
+	start = int(p.next.Add(1)-1) % n
+	for i := range n {
+		b := p.Backends[(start+i)%n]
+		if !p.available(b, exclude) {
+			continue
+		}
+		if p.Strategy != LeastConnections {
+			return b
+		}
+		if best == nil || b.active.Load() < best.active.Load() {
+			best = b
+		}
+	}
+	return best
+}
+
+// ObserveResult records the outcome of a request to b for passive health
+// checking. Server errors and failed deliveries count as failures.
+func (p *Pool) ObserveResult(b *Backend, ok bool) {
+	if ok {
+		b.failures.Store(0)
+		return
+	}
+	if p.MaxFailures > 0 && int(b.failures.Add(1)) >= p.MaxFailures && b.healthy.Swap(false) {
+		b.ejected.Store(time.Now().UnixNano())
+		logger.Warn("backend ejected", "backend", b.URL.String(), "failures", b.failures.Load())
+	}
+}
+
+// CheckHealth requests HealthCheckPath from every backend once and updates
+// their health.
+func (p *Pool) CheckHealth() {
+	var (
+		transport http.RoundTripper = p.Transport
+		wg        sync.WaitGroup
+	)
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for _, b := range p.Backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), p.HealthCheckTimeout)
+			defer cancel()
+			u := b.URL.JoinPath(p.HealthCheckPath)
+			req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+			if err != nil {
+				return
+			}
+			res, err := transport.RoundTrip(req)
+			healthy := err == nil && res.StatusCode < 400
+			if err == nil {
+				res.Body.Close()
+			}
+			if b.healthy.Swap(healthy) != healthy {
+				logger.Info("backend health changed", "backend", b.URL.String(), "healthy", healthy)
+			}
+			if healthy {
+				b.failures.Store(0)
+				b.ejected.Store(0)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Start runs active health checks in the background until Close is called.
+// It does nothing if HealthCheckPath is empty.
+func (p *Pool) Start() {
+	if p.HealthCheckPath == "" || p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	p.checking.Store(true)
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(p.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			p.CheckHealth()
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the health checks started by Start.
+func (p *Pool) Close() {
+	if p.stop != nil {
+		close(p.stop)
+		p.done.Wait()
+		p.stop = nil
+		p.checking.Store(false)
+	}
+}
+
+// idempotent methods can safely be retried on another backend
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
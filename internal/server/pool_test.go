@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend answers with its name and can be switched to failing health checks
+type fakeBackend struct {
+	*httptest.Server
+	name      string
+	unhealthy atomic.Bool
+	hits      atomic.Int32
+}
+
+func newFakeBackend(t *testing.T, name string) *fakeBackend {
+	t.Helper()
+	b := &fakeBackend{name: name}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			if b.unhealthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		b.hits.Add(1)
+		fmt.Fprint(w, b.name)
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func testPool(t *testing.T, strategy Strategy, backends ...*fakeBackend) *Pool {
+	t.Helper()
+	var urls []string
+	for _, b := range backends {
+		urls = append(urls, b.URL)
+	}
+	p, err := NewPool(strategy, urls...)
+	require.NoError(t, err)
+	return p
+}
+
+func poolRequest(hdrs headers.Headers) *request.Request {
+	if hdrs == nil {
+		hdrs = headers.NewHeaders()
+	}
+	return &request.Request{RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"}, Headers: hdrs}
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	a, b, c := newFakeBackend(t, "a"), newFakeBackend(t, "b"), newFakeBackend(t, "c")
+	p := testPool(t, RoundRobin, a, b, c)
+	proxy := &ReverseProxy{Pool: p}
+
+	// Test: Requests go to each backend in turn
+	var got []string
+	for range 6 {
+		_, body := serveRaw(t, proxy.Handler, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+		got = append(got, body)
+	}
+	assert.Equal(t, "abcabc", strings.Join(got, ""))
+}
+
+func TestPoolLeastConnections(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	p := testPool(t, LeastConnections, a, b)
+
+	// Test: The backend with fewer requests in flight is picked
+	p.Backends[0].active.Store(2)
+	p.Backends[1].active.Store(1)
+	for range 4 {
+		assert.Same(t, p.Backends[1], p.Pick(poolRequest(nil), nil))
+	}
+	p.Backends[1].active.Store(5)
+	assert.Same(t, p.Backends[0], p.Pick(poolRequest(nil), nil))
+}
+
+func TestPoolConsistentHash(t *testing.T) {
+	var backends []*fakeBackend
+	for i := range 5 {
+		backends = append(backends, newFakeBackend(t, fmt.Sprint(i)))
+	}
+	p := testPool(t, ConsistentHash, backends...)
+	p.HashHeader = "X-User"
+
+	// Test: The same key always maps to the same backend
+	seen := make(map[*Backend]bool)
+	picked := make(map[string]*Backend)
+	for i := range 50 {
+		key := fmt.Sprintf("user-%d", i)
+		picked[key] = p.Pick(poolRequest(headers.Headers{{Name: "x-user", Value: key}}), nil)
+		assert.Same(t, picked[key], p.Pick(poolRequest(headers.Headers{{Name: "x-user", Value: key}}), nil))
+		seen[picked[key]] = true
+	}
+	assert.Greater(t, len(seen), 1)
+
+	// Test: Only keys of an ejected backend move
+	gone := p.Backends[2]
+	gone.healthy.Store(false)
+	for key, b := range picked {
+		now := p.Pick(poolRequest(headers.Headers{{Name: "x-user", Value: key}}), nil)
+		if b == gone {
+			assert.NotSame(t, gone, now, key)
+		} else {
+			assert.Same(t, b, now, key)
+		}
+	}
+
+	// Test: Backends added later join the ring
+	gone.healthy.Store(true)
+	u, err := parseUpstream(newFakeBackend(t, "added").URL)
+	require.NoError(t, err)
+	added := &Backend{URL: u}
+	added.healthy.Store(true)
+	p.Backends = append(p.Backends, added)
+	for key, b := range picked {
+		now := p.Pick(poolRequest(headers.Headers{{Name: "x-user", Value: key}}), nil)
+		if now != added {
+			assert.Same(t, b, now, key)
+		}
+	}
+	seen = make(map[*Backend]bool)
+	for i := range 200 {
+		seen[p.Pick(poolRequest(headers.Headers{{Name: "x-user", Value: fmt.Sprintf("new-%d", i)}}), nil)] = true
+	}
+	assert.True(t, seen[added])
+}
+
+func TestPoolPassiveEjectionAndRetry(t *testing.T) {
+	var (
+		body string
+		res  *http.Response
+	)
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	p := testPool(t, RoundRobin, a, b)
+	p.MaxFailures = 2
+	proxy := &ReverseProxy{Pool: p}
+	a.Close()
+
+	// Test: Idempotent requests failing on one backend are retried on another
+	for range 2 {
+		res, body = serveRaw(t, proxy.Handler, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "b", body)
+	}
+
+	// Test: Consecutive failures eject the backend
+	assert.False(t, p.Backends[0].Healthy())
+	assert.True(t, p.Backends[1].Healthy())
+
+	// Test: Without running health checks, ejection lasts EjectTime even if
+	// HealthCheckPath is set
+	p.HealthCheckPath = "/healthz"
+	p.EjectTime = time.Hour
+	assert.Same(t, p.Backends[1], p.Pick(poolRequest(nil), nil))
+	p.EjectTime = 0
+	assert.Same(t, p.Backends[0], p.Pick(poolRequest(nil), []*Backend{p.Backends[1]}))
+	assert.True(t, p.Backends[0].Healthy())
+
+	// Test: Non-idempotent requests are not retried
+	p.Backends[0].healthy.Store(true)
+	p.Backends[0].failures.Store(0)
+	p.next.Store(0)
+	hits := b.hits.Load()
+	res, _ = serveRaw(t, proxy.Handler, "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 2\r\n\r\nhi")
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	assert.Equal(t, hits, b.hits.Load())
+
+	// Test: No backend left
+	p.Backends[1].healthy.Store(false)
+	p.Backends[0].healthy.Store(false)
+	res, _ = serveRaw(t, proxy.Handler, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+}
+
+func TestPoolHealthChecks(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	p := testPool(t, RoundRobin, a, b)
+	p.HealthCheckPath = "/healthz"
+
+	// Test: Failing health checks take a backend out of rotation
+	a.unhealthy.Store(true)
+	p.CheckHealth()
+	assert.False(t, p.Backends[0].Healthy())
+	for range 3 {
+		assert.Same(t, p.Backends[1], p.Pick(poolRequest(nil), nil))
+	}
+
+	// Test: Passing health checks bring it back
+	a.unhealthy.Store(false)
+	p.CheckHealth()
+	assert.True(t, p.Backends[0].Healthy())
+
+	// Test: The path is joined to upstreams ending in a slash
+	p, err := NewPool(RoundRobin, a.URL+"/")
+	require.NoError(t, err)
+	p.HealthCheckPath = "/healthz"
+	a.unhealthy.Store(true)
+	p.CheckHealth()
+	assert.False(t, p.Backends[0].Healthy())
+	assert.Zero(t, a.hits.Load())
+
+	// Test: Ejected backends wait for a health check while checks are running
+	p.HealthCheckInterval = time.Hour
+	p.EjectTime = 0
+	p.Start()
+	p.Backends[0].healthy.Store(false)
+	p.Backends[0].ejected.Store(time.Now().UnixNano())
+	assert.Nil(t, p.Pick(poolRequest(nil), nil))
+
+	// Test: Background checks stop on Close, with EjectTime applying again
+	p.Close()
+	assert.Same(t, p.Backends[0], p.Pick(poolRequest(nil), nil))
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dragonicorn/httpfromtcp/internal/digest"
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+const BUFFER_SIZE int = 4096
+
+// hop-by-hop fields apply to a single connection and are never forwarded
+var hopHeaders = []string{
+	"connection",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"proxy-connection",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// isHopHeader reports whether key is hop-by-hop, either always or because the
+// Connection field value lists it.
+func isHopHeader(key string, connection string) bool {
+	key = strings.ToLower(key)
+	if slices.Contains(hopHeaders, key) {
+		return true
+	}
+	for _, name := range strings.Split(connection, ",") {
+		if strings.ToLower(strings.TrimSpace(name)) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ReverseProxy forwards requests to the Upstream server, or to a backend of
+// Pool if set, and relays its responses.
+type ReverseProxy struct {
+	Upstream *url.URL
+	Pool     *Pool
+	// Prefix is stripped from the request target before it is appended to the
+	// upstream path.
+	Prefix string
+	// Transport performs the upstream requests. Redirects are relayed to the
+	// client rather than followed. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// ModifyResponse, if set, may alter the upstream response before it is
+	// relayed. Entries added to its Trailer are announced and sent after the
+	// body, which is then always chunked.
+	ModifyResponse func(*http.Response) error
+	// DigestTrailers, if set, are the algorithms of a Content-Digest trailer
+	// computed over the relayed body as it streams through, which is then
+	// always chunked.
+	DigestTrailers []digest.Algorithm
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Error: unsupported upstream scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
+func NewReverseProxy(upstream string) (*ReverseProxy, error) {
+	u, err := parseUpstream(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return &ReverseProxy{Upstream: u}, nil
+}
+
+// outgoing builds the request for req to the upstream server.
+func (p *ReverseProxy) outgoing(upstream *url.URL, req *request.Request) (*http.Request, error) {
+	var (
+		err    error
+		out    *http.Request
+		target *url.URL
+		u      url.URL
+	)
+	target, err = url.ParseRequestURI(strings.TrimPrefix(req.RequestLine.RequestTarget, p.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	u = *upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(target.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = target.RawQuery
+	out, err = http.NewRequest(req.RequestLine.Method, u.String(), bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	out.ContentLength = int64(len(req.Body))
+	connection := req.Headers.Get("Connection")
+	for _, f := range req.Headers {
+		if isHopHeader(f.Name, connection) || strings.EqualFold(f.Name, "Host") || strings.EqualFold(f.Name, "Content-Length") {
+			continue
+		}
+		out.Header.Add(textproto.CanonicalMIMEHeaderKey(f.Name), f.Value)
+	}
+	addForwarded(out.Header, req)
+	return out, nil
+}
+
+// addForwarded records the client and the original host in the X-Forwarded-*
+// fields and the standard Forwarded field, appending to any set by earlier
+// proxies.
+func addForwarded(h http.Header, req *request.Request) {
+	var (
+		client string
+		err    error
+		node   string
+	)
+	client, _, err = net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		client = req.RemoteAddr
+	}
+	if client == "" {
+		return
+	}
+	if prior := h.Get("X-Forwarded-For"); prior != "" {
+		h.Set("X-Forwarded-For", prior+", "+client)
+	} else {
+		h.Set("X-Forwarded-For", client)
+	}
+	node = client
+	if strings.Contains(client, ":") {
+		// IPv6 addresses are bracketed and quoted
+		node = "\"[" + client + "]\""
+	}
+	forwarded := "for=" + node + ";proto=http"
+	if host := req.Headers.Get("Host"); host != "" {
+		h.Set("X-Forwarded-Host", host)
+		forwarded += ";host=" + strconv.Quote(host)
+	}
+	h.Set("X-Forwarded-Proto", "http")
+	if prior := h.Get("Forwarded"); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	h.Set("Forwarded", forwarded)
+}
+
+// Handler forwards req upstream and streams the response back to the client.
+func (p *ReverseProxy) Handler(w *response.Writer, req *request.Request) error {
+	var (
+		err       error
+		out       *http.Request
+		res       *http.Response
+		transport http.RoundTripper = p.Transport
+	)
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if p.Pool != nil {
+		return p.poolHandler(w, req, transport)
+	}
+	out, err = p.outgoing(p.Upstream, req)
+	if err != nil {
+		return w.WriteError(response.StatusCode400, nil)
+	}
+	res, err = transport.RoundTrip(out)
+	if err != nil {
+		logger.Warn("error contacting upstream", "upstream", out.URL.String(), "error", err)
+		return w.WriteError(response.StatusCode502, nil)
+	}
+	return p.relay(w, req, res)
+}
+
+// poolHandler forwards req to a backend picked from the pool, retrying
+// idempotent requests on other backends when delivery fails.
+func (p *ReverseProxy) poolHandler(w *response.Writer, req *request.Request, transport http.RoundTripper) error {
+	var (
+		b     *Backend
+		err   error
+		out   *http.Request
+		res   *http.Response
+		tried []*Backend
+	)
+	for attempt := 0; ; attempt++ {
+		b = p.Pool.Pick(req, tried)
+		if b == nil {
+			logger.Warn("no backend available", "tried", len(tried))
+			return w.WriteError(response.StatusCode502, nil)
+		}
+		tried = append(tried, b)
+		out, err = p.outgoing(b.URL, req)
+		if err != nil {
+			return w.WriteError(response.StatusCode400, nil)
+		}
+		b.active.Add(1)
+		res, err = transport.RoundTrip(out)
+		if err == nil {
+			break
+		}
+		b.active.Add(-1)
+		p.Pool.ObserveResult(b, false)
+		logger.Warn("error contacting backend", "backend", b.URL.String(), "attempt", attempt+1, "error", err)
+		if attempt >= p.Pool.Retries || !idempotent(req.RequestLine.Method) {
+			return w.WriteError(response.StatusCode502, nil)
+		}
+	}
+	defer b.active.Add(-1)
+	p.Pool.ObserveResult(b, res.StatusCode < 500 || res.StatusCode > 504)
+	return p.relay(w, req, res)
+}
+
+// relay writes the upstream response res to the client.
+func (p *ReverseProxy) relay(w *response.Writer, req *request.Request, res *http.Response) error {
+	var (
+		digests []*digest.Digest
+		err     error
+		h       headers.Headers
+		names   []string
+	)
+	defer res.Body.Close()
+	if p.ModifyResponse != nil {
+		err = p.ModifyResponse(res)
+		if err != nil {
+			logger.Warn("error modifying upstream response", "upstream", res.Request.URL.String(), "error", err)
+			return w.WriteError(response.StatusCode502, nil)
+		}
+	}
+
+	// relay the upstream status and end-to-end fields
+	err = w.WriteStatusLine(response.StatusCode(res.StatusCode))
+	if err != nil {
+		return err
+	}
+	h = headers.NewHeaders()
+	connection := res.Header.Get("Connection")
+	for _, k := range slices.Sorted(maps.Keys(res.Header)) {
+		if isHopHeader(k, connection) || strings.EqualFold(k, "Content-Length") {
+			continue
+		}
+		for _, v := range res.Header[k] {
+			h.Add(k, v)
+		}
+	}
+	bodyless := req.RequestLine.Method == "HEAD" || !response.StatusCode(res.StatusCode).AllowsBody()
+	if res.ContentLength >= 0 && ((len(res.Trailer) == 0 && len(p.DigestTrailers) == 0) || bodyless) {
+		h.Set("Content-Length", strconv.FormatInt(res.ContentLength, 10))
+		err = w.WriteHeaders(h)
+		if err != nil || bodyless {
+			return err
+		}
+		_, err = w.WriteBodyFrom(res.Body)
+		return err
+	}
+	if bodyless {
+		return w.WriteHeaders(h)
+	}
+	h.Set("Transfer-Encoding", "chunked")
+	for k := range res.Trailer {
+		names = append(names, k)
+	}
+	if len(p.DigestTrailers) > 0 {
+		for _, alg := range p.DigestTrailers {
+			d, err := w.Digest(alg)
+			if err != nil {
+				return err
+			}
+			digests = append(digests, d)
+		}
+		if _, ok := res.Trailer["Content-Digest"]; !ok {
+			names = append(names, "Content-Digest")
+		}
+	}
+	if len(names) > 0 {
+		slices.Sort(names)
+		h.Set("Trailer", strings.Join(names, ", "))
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	return relayChunked(w, res, digests)
+}
+
+// relayChunked streams the upstream body as chunks, followed by any trailers
+// and the Content-Digest of the body if digests are given.
+func relayChunked(w *response.Writer, res *http.Response, digests []*digest.Digest) error {
+	var (
+		buf []byte
+		err error
+		h   headers.Headers
+		n   int
+	)
+	buf = make([]byte, BUFFER_SIZE)
+	w.State = response.StateChunkedBody
+	for {
+		n, err = res.Body.Read(buf)
+		if n > 0 {
+			// write chunk to response
+			_, werr := w.WriteChunkedBody(buf[:n])
+			if werr == nil {
+				_, werr = w.Body.WriteTo(w.Writer)
+			}
+			if werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	w.State = response.StateChunkedBodyDone
+	_, err = w.WriteChunkedBodyDone()
+	if err == nil {
+		_, err = w.Body.WriteTo(w.Writer)
+	}
+	if err != nil {
+		return err
+	}
+	h = headers.NewHeaders()
+	for _, k := range slices.Sorted(maps.Keys(res.Trailer)) {
+		for _, v := range res.Trailer[k] {
+			h.Add(k, v)
+		}
+	}
+	if len(digests) > 0 {
+		h.Set("Content-Digest", digest.Field(digests...))
+	}
+	err = w.WriteTrailers(h)
+	if err == nil {
+		_, err = w.Body.WriteTo(w.Writer)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dragonicorn/httpfromtcp/internal/digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upstreamHandler stands in for the proxied server
+func upstreamHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/echo":
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Query", r.URL.RawQuery)
+		w.Header().Set("X-Got-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Got-Forwarded", r.Header.Get("Forwarded"))
+		w.Header().Set("X-Got-Keep-Alive", r.Header.Get("Keep-Alive"))
+		w.Header().Set("X-Got-Custom-Hop", r.Header.Get("X-Custom-Hop"))
+		w.Header().Set("X-Got-Authorization", r.Header.Get("Authorization"))
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Write(body)
+	case "/api/missing":
+		http.Error(w, "nothing here", http.StatusNotFound)
+	case "/api/stream":
+		w.Header().Set("Trailer", "X-Checksum")
+		for i := range 3 {
+			fmt.Fprintf(w, "part %d\n", i)
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("X-Checksum", "abc")
+	case "/api/redirect":
+		http.Redirect(w, r, "/api/echo", http.StatusFound)
+	}
+}
+
+func testProxy(t *testing.T) *ReverseProxy {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(upstreamHandler))
+	t.Cleanup(upstream.Close)
+	p, err := NewReverseProxy(upstream.URL + "/api")
+	require.NoError(t, err)
+	p.Prefix = "/proxy"
+	return p
+}
+
+func TestReverseProxy(t *testing.T) {
+	var (
+		body string
+		p    *ReverseProxy
+		res  *http.Response
+	)
+	p = testProxy(t)
+
+	// Test: Any method with its body and query is forwarded
+	res, body = serveRaw(t, p.Handler, "PUT /proxy/echo?a=1&b=2 HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Authorization: Bearer token\r\n"+
+		"Content-Length: 11\r\n"+
+		"\r\n"+
+		"hello proxy")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "hello proxy", body)
+	assert.Equal(t, "PUT", res.Header.Get("X-Method"))
+	assert.Equal(t, "a=1&b=2", res.Header.Get("X-Query"))
+	assert.Equal(t, "Bearer token", res.Header.Get("X-Got-Authorization"))
+
+	// Test: Client address is added to the forwarding fields
+	assert.Equal(t, "192.0.2.1", res.Header.Get("X-Got-Forwarded-For"))
+	assert.Equal(t, `for=192.0.2.1;proto=http;host="localhost:42069"`, res.Header.Get("X-Got-Forwarded"))
+
+	// Test: Hop-by-hop fields are stripped in both directions
+	res, _ = serveRaw(t, p.Handler, "GET /proxy/echo HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Connection: keep-alive, X-Custom-Hop\r\n"+
+		"Keep-Alive: timeout=10\r\n"+
+		"X-Custom-Hop: secret\r\n"+
+		"X-Forwarded-For: 203.0.113.7\r\n"+
+		"\r\n")
+	assert.Equal(t, "", res.Header.Get("X-Got-Keep-Alive"))
+	assert.Equal(t, "", res.Header.Get("X-Got-Custom-Hop"))
+	assert.Equal(t, "", res.Header.Get("Keep-Alive"))
+	assert.Equal(t, "203.0.113.7, 192.0.2.1", res.Header.Get("X-Got-Forwarded-For"))
+}
+
+func TestReverseProxyStatus(t *testing.T) {
+	var (
+		body string
+		p    *ReverseProxy
+		res  *http.Response
+	)
+	p = testProxy(t)
+
+	// Test: Upstream error statuses are propagated
+	res, body = serveRaw(t, p.Handler, "GET /proxy/missing HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "nothing here\n", body)
+
+	// Test: Redirects are relayed rather than followed
+	res, _ = serveRaw(t, p.Handler, "GET /proxy/redirect HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/api/echo", res.Header.Get("Location"))
+
+	// Test: Unreachable upstream
+	p.Upstream.Host = "127.0.0.1:1"
+	res, _ = serveRaw(t, p.Handler, "GET /proxy/echo HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+}
+
+func TestReverseProxyStreaming(t *testing.T) {
+	var (
+		body string
+		p    *ReverseProxy
+		res  *http.Response
+	)
+	p = testProxy(t)
+
+	// Test: Bodies without a length are relayed chunked with their trailers
+	res, body = serveRaw(t, p.Handler, "GET /proxy/stream HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "part 0\npart 1\npart 2\n", body)
+	assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"))
+
+	// Test: Trailers can be added to a relayed response
+	p.ModifyResponse = func(res *http.Response) error {
+		res.Trailer = http.Header{"X-Added": []string{"yes"}}
+		return nil
+	}
+	res, body = serveRaw(t, p.Handler, "GET /proxy/echo HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "yes", res.Trailer.Get("X-Added"))
+}
+
+func TestReverseProxyDigestTrailers(t *testing.T) {
+	var (
+		body string
+		p    *ReverseProxy
+		res  *http.Response
+	)
+	p = testProxy(t)
+	p.DigestTrailers = []digest.Algorithm{digest.SHA256, digest.CRC32C}
+
+	// Test: The digest of a streamed body is sent as a trailer
+	res, body = serveRaw(t, p.Handler, "GET /proxy/stream HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"))
+	assert.NoError(t, digest.Verify(res.Trailer.Get("Content-Digest"), []byte(body)))
+	assert.Contains(t, res.Trailer.Get("Content-Digest"), "crc32c=:")
+
+	// Test: Bodies of known length are also digested
+	res, body = serveRaw(t, p.Handler, "POST /proxy/echo HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Equal(t, "hello", body)
+	assert.NoError(t, digest.Verify(res.Trailer.Get("Content-Digest"), []byte(body)))
+
+	// Test: Bodyless responses have no trailers
+	res, _ = serveRaw(t, p.Handler, "HEAD /proxy/echo HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Empty(t, res.TransferEncoding)
+	assert.Empty(t, res.Trailer)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+var (
+	// ErrInvalidRange is returned for a Range header that does not parse; the
+	// header is then ignored and the full representation served.
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrUnsatisfiableRange is returned when no requested range overlaps the
+	// representation, which is answered with 416 Range Not Satisfiable.
+	ErrUnsatisfiableRange = errors.New("range not satisfiable")
+)
+
+// ByteRange is a satisfiable range of a representation.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange formats r for the Content-Range header.
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header value such as "bytes=0-99,200-,-50" for a
+// representation of size bytes. Ranges extending past the end are truncated
+// and those starting past the end are dropped.
+func ParseRange(s string, size int64) ([]ByteRange, error) {
+	var (
+		err        error
+		ranges     []ByteRange
+		start, end int64
+		unit, set  string
+		ok         bool
+	)
+	unit, set, ok = strings.Cut(s, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, ErrInvalidRange
+	}
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ErrInvalidRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		if first == "" {
+			// suffix range: the last N bytes
+			end, err = parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if end == 0 || size == 0 {
+				continue
+			}
+			start = max(size-end, 0)
+			ranges = append(ranges, ByteRange{start, size - start})
+			continue
+		}
+		start, err = parseRangeInt(first)
+		if err != nil {
+			return nil, err
+		}
+		end = size - 1
+		if last != "" {
+			end, err = parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, ErrInvalidRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, ByteRange{start, end - start + 1})
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ErrInvalidRange
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidRange
+	}
+	return n, nil
+}
+
+// ifRangeMatches reports whether the If-Range validator still matches the
+// representation. Entity tags must match strongly; dates must match exactly.
+func ifRangeMatches(ifRange string, modtime time.Time, etag string) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	t, err := headers.ParseTime(ifRange)
+	return err == nil && !modtime.IsZero() && modtime.Truncate(time.Second).Equal(t)
+}
+
+// rangesFor returns the ranges of a representation of size bytes requested by
+// req, or none if the full representation should be sent.
+func rangesFor(req *request.Request, size int64, modtime time.Time, etag string) ([]ByteRange, error) {
+	var (
+		err    error
+		ranges []ByteRange
+		total  int64
+	)
+	if req.Headers.Get("Range") == "" {
+		return nil, nil
+	}
+	if req.Headers.Get("If-Range") != "" && !ifRangeMatches(req.Headers.Get("If-Range"), modtime, etag) {
+		return nil, nil
+	}
+	ranges, err = ParseRange(req.Headers.Get("Range"), size)
+	if err == ErrInvalidRange {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// requests asking for more than the whole representation, e.g. through
+	// many overlapping ranges, are answered with the full content instead
+	for _, r := range ranges {
+		total += r.Length
+	}
+	if total > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// serveRange writes a 206 response holding a single range of content.
+func serveRange(w *response.Writer, req *request.Request, h headers.Headers, ct string, size int64, r ByteRange, content io.ReadSeeker) error {
+	var err error
+	err = w.WriteStatusLine(response.StatusCode206)
+	if err != nil {
+		return err
+	}
+	h.Set("Content-Length", strconv.FormatInt(r.Length, 10))
+	h.Set("Content-Type", ct)
+	h.Set("Content-Range", r.ContentRange(size))
+	err = w.WriteHeaders(h)
+	if err != nil || req.RequestLine.Method == "HEAD" {
+		return err
+	}
+	_, err = content.Seek(r.Start, io.SeekStart)
+	if err == nil {
+		_, err = w.WriteBodyFrom(io.LimitReader(content, r.Length))
+	}
+	return err
+}
+
+// serveMultipartRanges writes a 206 multipart/byteranges response with one
+// part per range.
+func serveMultipartRanges(w *response.Writer, req *request.Request, h headers.Headers, ct string, size int64, ranges []ByteRange, content io.ReadSeeker) error {
+	var (
+		b        []byte
+		boundary string
+		err      error
+		length   int64
+		parts    []string
+	)
+	b = make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return err
+	}
+	boundary = hex.EncodeToString(b)
+	for _, r := range ranges {
+		part := fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, ct, r.ContentRange(size))
+		parts = append(parts, part)
+		length += int64(len(part)) + r.Length
+	}
+	end := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	length += int64(len(end))
+
+	err = w.WriteStatusLine(response.StatusCode206)
+	if err != nil {
+		return err
+	}
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	err = w.WriteHeaders(h)
+	if err != nil || req.RequestLine.Method == "HEAD" {
+		return err
+	}
+	for i, r := range ranges {
+		_, err = w.WriteBody([]byte(parts[i]))
+		if err == nil {
+			_, err = content.Seek(r.Start, io.SeekStart)
+		}
+		if err == nil {
+			_, err = w.WriteBodyFrom(io.LimitReader(content, r.Length))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.WriteBody([]byte(end))
+	return err
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		ranges []ByteRange
+		err    error
+	}{
+		{"bytes=0-499", []ByteRange{{0, 500}}, nil},
+		{"bytes=500-999", []ByteRange{{500, 500}}, nil},
+		{"bytes=-500", []ByteRange{{9500, 500}}, nil},
+		{"bytes=9500-", []ByteRange{{9500, 500}}, nil},
+		{"bytes=0-0,-1", []ByteRange{{0, 1}, {9999, 1}}, nil},
+		{"bytes= 0-10 , 20-30", []ByteRange{{0, 11}, {20, 11}}, nil},
+		{"BYTES=0-9", []ByteRange{{0, 10}}, nil},
+		{"bytes=9000-20000", []ByteRange{{9000, 1000}}, nil},
+		{"bytes=-20000", []ByteRange{{0, 10000}}, nil},
+		{"bytes=0-9,10000-", []ByteRange{{0, 10}}, nil},
+		{"bytes=10000-", nil, ErrUnsatisfiableRange},
+		{"bytes=-0", nil, ErrUnsatisfiableRange},
+		{"bytes=20-10", nil, ErrInvalidRange},
+		{"bytes=a-10", nil, ErrInvalidRange},
+		{"bytes=+1-10", nil, ErrInvalidRange},
+		{"bytes=10", nil, ErrInvalidRange},
+		{"items=0-10", nil, ErrInvalidRange},
+		{"0-10", nil, ErrInvalidRange},
+	}
+	for _, tt := range tests {
+		ranges, err := ParseRange(tt.header, 10000)
+		assert.Equal(t, tt.err, err, tt.header)
+		assert.Equal(t, tt.ranges, ranges, tt.header)
+	}
+}
+
+func contentHandler(modtime time.Time, etag string) Handler {
+	return func(w *response.Writer, req *request.Request) error {
+		return ServeContent(w, req, "digits.txt", modtime, etag, strings.NewReader("0123456789"))
+	}
+}
+
+func TestServeContentRanges(t *testing.T) {
+	var (
+		body    string
+		handler Handler
+		modtime time.Time = time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+		res     *http.Response
+	)
+	handler = contentHandler(modtime, `"v1"`)
+
+	// Test: Full content advertises range support
+	res, body = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "bytes", res.Header.Get("Accept-Ranges"))
+	assert.Equal(t, "0123456789", body)
+
+	// Test: Single range
+	res, body = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=2-4\r\n\r\n")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "bytes 2-4/10", res.Header.Get("Content-Range"))
+	assert.Equal(t, "3", res.Header.Get("Content-Length"))
+	assert.Equal(t, "234", body)
+
+	// Test: Suffix range
+	res, body = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=-3\r\n\r\n")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "bytes 7-9/10", res.Header.Get("Content-Range"))
+	assert.Equal(t, "789", body)
+
+	// Test: Unsatisfiable range
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=10-\r\n\r\n")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+	assert.Equal(t, "bytes */10", res.Header.Get("Content-Range"))
+
+	// Test: Invalid range is ignored
+	res, body = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=5-1\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "0123456789", body)
+
+	// Test: Ranges adding up to more than the content are served in full
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-9,0-9\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestServeContentMultipleRanges(t *testing.T) {
+	var (
+		body string
+		res  *http.Response
+	)
+	res, body = serveRaw(t, contentHandler(time.Time{}, ""), "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-1,-2\r\n\r\n")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	mt, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mt)
+
+	// Test: One part per range with its own Content-Range
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	expected := []struct{ cr, data string }{{"bytes 0-1/10", "01"}, {"bytes 8-9/10", "89"}}
+	for _, e := range expected {
+		p, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, e.cr, p.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain; charset=utf-8", p.Header.Get("Content-Type"))
+		data, err := io.ReadAll(p)
+		require.NoError(t, err)
+		assert.Equal(t, e.data, string(data))
+	}
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServeContentIfRange(t *testing.T) {
+	var (
+		handler Handler
+		modtime time.Time = time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+		res     *http.Response
+	)
+	handler = contentHandler(modtime, `"v1"`)
+
+	// Test: Matching entity tag honours the range
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-1\r\nIf-Range: \"v1\"\r\n\r\n")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+
+	// Test: Changed entity tag sends the full representation
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-1\r\nIf-Range: \"v2\"\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Test: Weak entity tags never match
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-1\r\nIf-Range: W/\"v1\"\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Test: Matching and stale dates
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-1\r\nIf-Range: Wed, 01 May 2024 12:00:00 GMT\r\n\r\n")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-1\r\nIf-Range: Tue, 30 Apr 2024 12:00:00 GMT\r\n\r\n")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestServeContentConditional(t *testing.T) {
+	var (
+		body    string
+		handler Handler
+		modtime time.Time = time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+		res     *http.Response
+	)
+	handler = contentHandler(modtime, `"v1"`)
+
+	// Test: Unchanged representation is not sent again
+	res, body = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nIf-None-Match: \"v1\"\r\n\r\n")
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	assert.Equal(t, `"v1"`, res.Header.Get("ETag"))
+	assert.Equal(t, "", body)
+
+	// Test: Conditions are evaluated before ranges
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-1\r\nIf-Modified-Since: Wed, 01 May 2024 12:00:00 GMT\r\n\r\n")
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	res, _ = serveRaw(t, handler, "GET /digits HTTP/1.1\r\nHost: localhost:42069\r\nRange: bytes=0-1\r\nIf-Match: \"v0\"\r\n\r\n")
+	assert.Equal(t, http.StatusPreconditionFailed, res.StatusCode)
+}
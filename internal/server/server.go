@@ -1,19 +1,18 @@
 package server
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"errors"
 	"fmt"
-	"hash"
 	"io"
+	"log/slog"
 	"net"
-	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/logging"
 	"github.com/dragonicorn/httpfromtcp/internal/request"
 	"github.com/dragonicorn/httpfromtcp/internal/response"
 )
@@ -24,125 +23,48 @@ type Server struct {
 	Closed   atomic.Bool
 	Listener net.Listener
 	Handler  Handler
+
+	conns       atomic.Uint64 // source of connection IDs for log records
+	accessLog   *AccessLog
+	metrics     *Metrics
+	compression *response.Compression
+	decodeLimit int64
 }
 
-func videoHandler(w *response.Writer, req *request.Request) error {
-	var (
-		buffer []byte
-		err    error
-		h      headers.Headers
-		n      int64
-	)
+// Option configures a Server before it starts accepting connections.
+type Option func(*Server)
 
-	buffer, err = os.ReadFile("./assets/vim.mp4")
-	if err != nil {
-		return err
-	}
-	err = w.WriteStatusLine(response.StatusCode200)
-	if err == nil {
-		h = response.GetDefaultHeaders(len(buffer))
-		h["Content-Type"] = "video/mp4"
-		err = w.WriteHeaders(h)
-		if err == nil {
-			_, err = w.WriteBody(buffer)
-			if err == nil {
-				n, err = w.Body.WriteTo(w.Writer)
-				if err != nil || n != int64(len(buffer)) {
-					fmt.Printf("Error writing to connection: %v\n", err)
-				}
-			}
-		}
+// WithAccessLog records every served request in a.
+func WithAccessLog(a *AccessLog) Option {
+	return func(s *Server) {
+		s.accessLog = a
 	}
-	return err
 }
 
-const BUFFER_SIZE int = 4096
-
-func httpbinHandler(w *response.Writer, req *request.Request) error {
-	var (
-		body    bytes.Buffer
-		buf     []byte
-		err     error
-		h       headers.Headers
-		hash    hash.Hash
-		b, l, n int
-		// o   int64
-		res *http.Response
-		url string = "https://httpbin.org" + strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
-	)
-	res, err = http.Get(url)
-	defer res.Body.Close()
-	if err != nil {
-		return err
-	}
-	if res.StatusCode > 299 {
-		err = w.WriteStatusLine(response.StatusCode400)
-		return fmt.Errorf("Error: Bad Status Code returned from %s\n", url)
+// WithCompression compresses the response bodies c deems compressible for
+// clients accepting one of its encodings.
+func WithCompression(c *response.Compression) Option {
+	return func(s *Server) {
+		s.compression = c
 	}
-	buf = make([]byte, BUFFER_SIZE)
+}
 
-	err = w.WriteStatusLine(response.StatusCode200)
-	if err != nil {
-		return err
-	}
-	h = response.GetDefaultHeaders(0)
-	delete(h, "Content-Length")
-	h["Transfer-Encoding"] = "chunked"
-	h["Content-Type"] = res.Header.Get("Content-Type")
-	h["Trailer"] = "X-Content-SHA256, X-Content-Length"
-	err = w.WriteHeaders(h)
-	if err != nil {
-		return err
-	}
-	w.State = response.StateChunkedBody
-	for w.State != response.StateChunkedBodyDone {
-		n, err = res.Body.Read(buf)
-		// fmt.Printf("%d bytes read from httpbin.org\n", n)
-		// io.EOF error will be returned if no more data is available and NO data was read into buffer
-		// io.ErrUnexpectedEOF error will be returned if no more data is available but SOME data was read into buffer
-		if (err == io.EOF && n == 0) || (err == io.ErrUnexpectedEOF) {
-			err = nil
-			w.State = response.StateChunkedBodyDone
-		} else if err != nil {
-			return err
-		}
-		if n > 0 {
-			b, err = body.Write(buf[:n])
-			if err != nil {
-				return err
-			}
-			// update length of body received
-			l += b
-			// write chunk to response
-			_, err = w.WriteChunkedBody(buf[:n])
-			if err == nil {
-				_, err = w.Body.WriteTo(w.Writer)
-			}
-			if err != nil {
-				return err
-			}
-			// fmt.Printf("%d bytes written to response channel\n", o)
-		}
-	}
-	_, err = w.WriteChunkedBodyDone()
-	if err == nil {
-		_, err = w.Body.WriteTo(w.Writer)
-	}
-	if err != nil {
-		return err
+// WithRequestDecoding decompresses gzip and deflate request bodies before they
+// reach the handler, refusing bodies that decode to more than maxSize bytes
+// with 413 and other content codings with 415.
+func WithRequestDecoding(maxSize int64) Option {
+	return func(s *Server) {
+		s.decodeLimit = maxSize
 	}
+}
 
-	// add trailers to chunked response
-	clear(h)
-	hash = sha256.New()
-	hash.Write(body.Bytes())
-	h["X-Content-SHA256"] = fmt.Sprintf("%x", hash.Sum(nil))
-	h["X-Content-Length"] = fmt.Sprintf("%d", l)
-	err = w.WriteTrailers(h)
-	if err == nil {
-		_, err = w.Body.WriteTo(w.Writer)
-	}
-	return err
+var logger = logging.Discard()
+
+// SetLogger sets the logger for connection and handler errors. Each connection
+// logs through a child logger carrying its ID, which is also passed to the
+// request parser for its debug traces. A nil logger silences the package.
+func SetLogger(l *slog.Logger) {
+	logger = logging.OrDiscard(l)
 }
 
 func (s *Server) Close() error {
@@ -158,7 +80,7 @@ func (s *Server) listen() {
 			if s.Closed.Load() {
 				return
 			}
-			fmt.Printf("Error accepting connection: %v\n", err)
+			logger.Error("error accepting connection", "error", err)
 		} else {
 			go s.handle(c)
 		}
@@ -168,55 +90,134 @@ func (s *Server) listen() {
 func (s *Server) handle(c net.Conn) {
 	var (
 		err   error
-		cl, n int64
+		log   *slog.Logger
 		req   *request.Request
-		w     response.Writer = response.Writer{
-			Writer: c,
-			State:  response.StateStatus,
-			// StatusCode: response.StatusCode,
-			// Headers:    headers.Headers,
-			// Body:       bytes.Buffer,
-		}
+		rr    *request.Reader
+		start time.Time
+		w     *response.Writer
 	)
-	defer c.Close()
+	defer func() {
+		// a hijacked connection belongs to the handler
+		if w == nil || !w.Hijacked() {
+			c.Close()
+		}
+	}()
+	if s.metrics != nil {
+		c = s.metrics.instrument(c)
+	}
+	log = logger.With("conn", s.conns.Add(1), "remote", c.RemoteAddr().String())
+	log.Debug("connection accepted")
+	defer func() {
+		if w == nil || !w.Hijacked() {
+			log.Debug("connection closed")
+		}
+	}()
 
-	// parse request from connection
-	req, err = request.RequestFromReader(c)
-	if err != nil {
-		fmt.Printf("Error parsing request: %v\n", err)
-		return
+	// requests are parsed and answered one at a time so that responses to
+	// pipelined requests are written in the order the requests were received
+	rr = request.NewReader(c)
+	rr.Logger = log
+	for {
+		// parse next request from connection - bytes following it stay buffered in rr
+		req, err = rr.ReadRequest()
+		if err != nil {
+			if err != io.EOF {
+				log.Warn("error parsing request", "error", err)
+				if s.metrics != nil {
+					s.metrics.ObserveParseError(err)
+				}
+			}
+			return
+		}
+		req.RemoteAddr = c.RemoteAddr().String()
+		start = time.Now()
+		w = response.NewWriter(c)
+		w.SetConn(c, rr.Buffered)
+		err = s.respond(w, req)
+		if s.metrics != nil {
+			s.metrics.ObserveRequest(req.RequestLine.Method, int(w.StatusCode), time.Since(start))
+		}
+		if s.accessLog != nil {
+			if logErr := s.accessLog.Log(NewAccessLogEntry(c.RemoteAddr().String(), start, req, w)); logErr != nil {
+				log.Error("error writing access log", "error", logErr)
+			}
+		}
+		if err != nil {
+			log.Error("error in handler function", "method", req.RequestLine.Method, "target", req.RequestLine.RequestTarget, "error", err)
+			return
+		}
+		if w.Hijacked() {
+			log.Debug("connection hijacked")
+			return
+		}
+		if !keepAlive(w, req) {
+			return
+		}
 	}
+}
 
-	// check for proxy request to httpbin.org
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		var handler = s.Handler
-		s.Handler = httpbinHandler
-		err = s.Handler(&w, req)
-		s.Handler = handler
-	} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/video") {
-		var handler = s.Handler
-		s.Handler = videoHandler
-		err = s.Handler(&w, req)
-		s.Handler = handler
-	} else {
-		// handle standard request
-		err = s.Handler(&w, req)
-		if err == nil {
-			cl, err = strconv.ParseInt((w.Headers["Content-Length"]), 10, 64)
+// respond passes a single request to the appropriate handler.
+func (s *Server) respond(w *response.Writer, req *request.Request) error {
+	var (
+		cl  int64
+		err error
+	)
+	if s.compression != nil && req.RequestLine.Method != "HEAD" {
+		w.Compress(s.compression, req.Headers.Get("Accept-Encoding"))
+	}
+	if s.decodeLimit > 0 {
+		done, err := s.decodeBody(w, req)
+		if done || err != nil {
 			if err == nil {
-				n, err = w.Body.WriteTo(c)
-				if err != nil || n != cl {
-					fmt.Printf("Error writing to connection: %v\n", err)
-				}
+				err = w.Close()
 			}
+			return err
 		}
 	}
-	if err != nil {
-		fmt.Printf("Error in handler function: %v\n", err)
+	err = s.Handler(w, req)
+	if err == nil && !w.Hijacked() {
+		// write any body left buffered by the handler - streamed bodies are already sent
+		err = w.Close()
+		if err == nil && req.RequestLine.Method != "HEAD" && w.StatusCode.AllowsBody() && w.Headers.Get("Content-Length") != "" {
+			cl, err = strconv.ParseInt(w.Headers.Get("Content-Length"), 10, 64)
+			if err == nil && w.BytesWritten() != cl {
+				err = fmt.Errorf("Error: %d body bytes written, Content-Length is %d", w.BytesWritten(), cl)
+			}
+		}
 	}
+	return err
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
+// decodeBody decompresses the request body, answering requests whose body
+// cannot be decoded itself. It reports whether it wrote a response.
+func (s *Server) decodeBody(w *response.Writer, req *request.Request) (bool, error) {
+	err := req.DecodeBody(s.decodeLimit)
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.Is(err, request.ErrUnsupportedEncoding):
+		return true, w.WriteError(response.StatusCode415, headers.Headers{{Name: "Accept-Encoding", Value: strings.Join(request.SupportedEncodings, ", ")}})
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return true, w.WriteError(response.StatusCode413, nil)
+	}
+	return true, w.WriteError(response.StatusCode400, nil)
+}
+
+// keepAlive reports whether the connection can be reused for another request
+// once the response has been written.
+func keepAlive(w *response.Writer, req *request.Request) bool {
+	if req.Headers.HasToken("Connection", "close") || w.Headers.HasToken("Connection", "close") {
+		return false
+	}
+	if req.RequestLine.Method == "HEAD" || !w.StatusCode.AllowsBody() {
+		return true
+	}
+	// without a length or chunked framing the client can only detect the end of
+	// the body by the connection closing
+	return w.Headers.Get("Content-Length") != "" || w.Headers.HasToken("Transfer-Encoding", "chunked")
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	var (
 		l      net.Listener
 		server Server
@@ -224,11 +225,14 @@ func Serve(port int, handler Handler) (*Server, error) {
 	)
 	l, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		fmt.Printf("Error opening port %d: %v\n", port, err)
+		logger.Error("error opening port", "port", port, "error", err)
 		return nil, err
 	}
 	server.Handler = handler
 	server.Listener = l
+	for _, opt := range opts {
+		opt(&server)
+	}
 	server.Closed.Store(false)
 	go server.listen()
 	return &server, nil
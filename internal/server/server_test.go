@@ -0,0 +1,384 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoHandler responds with the request target and body, keeping the connection open
+func echoHandler(w *response.Writer, req *request.Request) error {
+	var (
+		err error
+		msg string
+	)
+	// delay the first request so that an out of order response would be detected
+	if req.RequestLine.RequestTarget == "/first" {
+		time.Sleep(50 * time.Millisecond)
+	}
+	msg = req.RequestLine.RequestTarget + " " + string(req.Body)
+	err = w.WriteStatusLine(response.StatusCode200)
+	if err == nil {
+		h := response.GetDefaultHeaders(len(msg))
+		h.Set("Connection", "keep-alive")
+		h.Set("Content-Type", "text/plain")
+		err = w.WriteHeaders(h)
+		if err == nil {
+			_, err = w.WriteBody([]byte(msg))
+		}
+	}
+	return err
+}
+
+func startServer(t *testing.T, handler Handler, opts ...Option) *Server {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// serveRaw passes the raw request to handler as the server would and parses the response
+func serveRaw(t *testing.T, handler Handler, raw string) (*http.Response, string) {
+	t.Helper()
+	var (
+		buf bytes.Buffer
+		s   Server
+	)
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:51234"
+	s.Handler = handler
+	err = s.respond(response.NewWriter(&buf), req)
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: req.RequestLine.Method})
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func readBody(t *testing.T, rd *bufio.Reader) string {
+	t.Helper()
+	res, err := http.ReadResponse(rd, nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	return string(body)
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	var (
+		c   net.Conn
+		err error
+		rd  *bufio.Reader
+		s   *Server
+	)
+	s = startServer(t, echoHandler)
+	c, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	// Test: several requests sent in a single write are answered in order
+	_, err = c.Write([]byte("POST /first HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello" +
+		"GET /second HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"\r\n" +
+		"POST /third HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 6\r\n" +
+		"Connection: close\r\n" +
+		"\r\n" +
+		"world!"))
+	require.NoError(t, err)
+	rd = bufio.NewReader(c)
+	assert.Equal(t, "/first hello", readBody(t, rd))
+	assert.Equal(t, "/second ", readBody(t, rd))
+	assert.Equal(t, "/third world!", readBody(t, rd))
+
+	// Test: connection is closed after a request with "Connection: close"
+	_, err = rd.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestKeepAlive(t *testing.T) {
+	var (
+		c   net.Conn
+		err error
+		rd  *bufio.Reader
+		s   *Server
+	)
+	s = startServer(t, echoHandler)
+	c, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	rd = bufio.NewReader(c)
+
+	// Test: requests sent one at a time share the connection
+	_, err = c.Write([]byte("GET /one HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/one ", readBody(t, rd))
+	_, err = c.Write([]byte("GET /two HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/two ", readBody(t, rd))
+}
+
+func TestCompression(t *testing.T) {
+	var (
+		c   net.Conn
+		err error
+		msg string
+		rd  *bufio.Reader
+		res *http.Response
+		s   *Server
+	)
+	s = startServer(t, echoHandler, WithCompression(response.NewCompression()))
+	c, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	rd = bufio.NewReader(c)
+	msg = strings.Repeat("compress me ", 200)
+
+	// Test: compressed responses keep the connection usable for the next request
+	for _, target := range []string{"/one", "/two"} {
+		_, err = fmt.Fprintf(c, "POST %s HTTP/1.1\r\nHost: localhost:42069\r\nAccept-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", target, len(msg), msg)
+		require.NoError(t, err)
+		res, err = http.ReadResponse(rd, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+		zr, err := gzip.NewReader(res.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, target+" "+msg, string(body))
+		res.Body.Close()
+	}
+
+	// Test: HEAD responses are left alone
+	_, err = c.Write([]byte("HEAD /three HTTP/1.1\r\nHost: localhost:42069\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(rd, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+}
+
+func TestRequestDecoding(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		err error
+		res *http.Response
+		s   *Server
+	)
+	s = startServer(t, echoHandler, WithRequestDecoding(1024))
+	post := func(encoding string, body []byte) (*http.Response, string) {
+		req, err := http.NewRequest("POST", "http://"+s.Listener.Addr().String()+"/upload", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Encoding", encoding)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res, string(b)
+	}
+	zw := gzip.NewWriter(&buf)
+	_, err = zw.Write([]byte("hello world!"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	// Test: handlers receive the decoded body
+	res, body := post("gzip", buf.Bytes())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "/upload hello world!", body)
+
+	// Test: unsupported encodings are refused
+	res, _ = post("br", []byte("hello"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+	assert.Equal(t, "gzip, deflate", res.Header.Get("Accept-Encoding"))
+
+	// Test: bodies decoding beyond the limit are refused
+	buf.Reset()
+	zw.Reset(&buf)
+	_, err = zw.Write(make([]byte, 4096))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	res, _ = post("gzip", buf.Bytes())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	// Test: corrupt bodies are bad requests
+	res, _ = post("gzip", []byte("not gzip"))
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+// mixedCaseHandler spells header names in whatever case the target asks for
+func mixedCaseHandler(w *response.Writer, req *request.Request) error {
+	var (
+		err error
+		h   headers.Headers
+		msg string = "hello world!\n"
+	)
+	err = w.WriteStatusLine(response.StatusCode200)
+	if err != nil {
+		return err
+	}
+	if req.RequestLine.RequestTarget == "/chunked" {
+		h.Set("content-type", "text/plain")
+		h.Set("TRANSFER-ENCODING", "Chunked")
+		err = w.WriteHeaders(h)
+		if err != nil {
+			return err
+		}
+		w.State = response.StateChunkedBody
+		_, err = w.WriteChunkedBody([]byte(msg))
+		if err == nil {
+			w.State = response.StateChunkedBodyDone
+			_, err = w.WriteChunkedBodyDone()
+		}
+		if err == nil {
+			_, err = w.Body.WriteTo(w.Writer)
+		}
+		if err == nil {
+			err = w.WriteTrailers(nil)
+		}
+		return err
+	}
+	h = response.GetDefaultHeaders(0)
+	h.Set("content-length", strconv.Itoa(len(msg)))
+	h.Set("CONNECTION", "Keep-Alive")
+	h.Set("content-TYPE", "text/plain")
+	err = w.WriteHeaders(h)
+	if err == nil {
+		_, err = w.WriteBody([]byte(msg))
+	}
+	return err
+}
+
+func TestMixedCaseHeaders(t *testing.T) {
+	var (
+		c   net.Conn
+		err error
+		rd  *bufio.Reader
+		res *http.Response
+		s   *Server
+	)
+	s = startServer(t, mixedCaseHandler)
+	c, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	rd = bufio.NewReader(c)
+
+	// Test: fields set in lower or upper case replace the defaults and keep the connection open
+	for _, target := range []string{"/lower", "/chunked", "/again"} {
+		_, err = c.Write([]byte("GET " + target + " HTTP/1.1\r\nhost: localhost:42069\r\nCONNECTION: Keep-Alive\r\n\r\n"))
+		require.NoError(t, err)
+		res, err = http.ReadResponse(rd, nil)
+		require.NoError(t, err, target)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err, target)
+		res.Body.Close()
+		assert.Equal(t, "hello world!\n", string(body), target)
+		assert.Len(t, res.Header.Values("Content-Type"), 1, target)
+		assert.Equal(t, "text/plain", res.Header.Get("Content-Type"), target)
+		if target == "/chunked" {
+			assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+		} else {
+			assert.Equal(t, int64(13), res.ContentLength, target)
+		}
+	}
+}
+
+// tunnelHandler answers CONNECT requests by echoing everything sent through the
+// tunnel in upper case, from a goroutine outliving the handler.
+func tunnelHandler(w *response.Writer, req *request.Request) error {
+	err := w.WriteStatusLine(response.StatusCode200)
+	if err == nil {
+		err = w.WriteHeaders(headers.NewHeaders())
+	}
+	if err != nil {
+		return err
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer conn.Close()
+		rd := io.MultiReader(bytes.NewReader(buffered), conn)
+		buf := make([]byte, 64)
+		for {
+			n, err := rd.Read(buf)
+			if n > 0 {
+				conn.Write(bytes.ToUpper(buf[:n]))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func TestHijack(t *testing.T) {
+	var (
+		c    net.Conn
+		err  error
+		line string
+		m    = NewMetrics()
+		rd   *bufio.Reader
+		s    *Server
+	)
+	s = startServer(t, tunnelHandler, WithMetrics(m))
+	c, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: Bytes sent along with the request are handed over with the connection
+	_, err = c.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nearly bytes, "))
+	require.NoError(t, err)
+	rd = bufio.NewReader(c)
+	line, err = rd.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+	line, err = rd.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+	got := make([]byte, len("EARLY BYTES, "))
+	_, err = io.ReadFull(rd, got)
+	require.NoError(t, err)
+	assert.Equal(t, "EARLY BYTES, ", string(got))
+
+	// Test: The server neither closes the connection nor parses it as HTTP
+	time.Sleep(20 * time.Millisecond)
+	_, err = c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	got = make([]byte, len("GET / HTTP/1.1\r\n\r\n"))
+	_, err = io.ReadFull(rd, got)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(got))
+
+	// Test: The connection stays active until the handler closes it
+	assert.Equal(t, int64(1), m.active.Load())
+	c.Close()
+	assert.Eventually(t, func() bool { return m.active.Load() == 0 }, time.Second, 5*time.Millisecond)
+}
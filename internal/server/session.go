@@ -0,0 +1,480 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/cookie"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+var (
+	ErrInvalidSessionKey = errors.New("invalid session key")
+	// ErrInvalidSession is returned for a session cookie that was tampered
+	// with, was made with an unknown key or has expired. The request then
+	// starts a new session.
+	ErrInvalidSession = errors.New("invalid session cookie")
+)
+
+// browsers ignore cookies longer than this, name and attributes included
+const maxCookieSize = 4096
+
+// SessionStore keeps session values on the server, the session cookie then
+// carrying only the session ID. Implementations must be safe for concurrent
+// use.
+type SessionStore interface {
+	// Load returns the values of the session id, or false if there is no such
+	// session or it has expired.
+	Load(id string) (map[string]string, bool, error)
+	// Save stores the values of the session id until expiry, or without limit
+	// if expiry is zero.
+	Save(id string, values map[string]string, expiry time.Time) error
+	Delete(id string) error
+}
+
+type memorySession struct {
+	values map[string]string
+	expiry time.Time
+}
+
+// MemoryStore is a SessionStore holding the sessions of this process.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]memorySession)}
+}
+
+func (m *MemoryStore) Load(id string) (map[string]string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || (!s.expiry.IsZero() && time.Now().After(s.expiry)) {
+		return nil, false, nil
+	}
+	return maps.Clone(s.values), true, nil
+}
+
+func (m *MemoryStore) Save(id string, values map[string]string, expiry time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	// expired sessions are dropped once a minute rather than on every save
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, s := range m.sessions {
+			if !s.expiry.IsZero() && now.After(s.expiry) {
+				delete(m.sessions, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	m.sessions[id] = memorySession{maps.Clone(values), expiry}
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// Len returns the number of sessions held, expired ones included until they
+// are swept.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// Session is the session of a request, obtained with Sessions.Session.
+type Session struct {
+	id      string // with a SessionStore only
+	values  map[string]string
+	created time.Time
+	isNew   bool
+	changed bool
+	// destroyed sessions are deleted and their cookie expired
+	destroyed bool
+	// the ID a renewed session had before, to be deleted from the store
+	oldID string
+}
+
+func (s *Session) Get(key string) string {
+	return s.values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	s.values[key] = value
+	s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.values, key)
+	s.changed = true
+}
+
+// IsNew reports whether the request came without a valid session cookie.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Created returns the time the session was started, from which its absolute
+// expiry is counted.
+func (s *Session) Created() time.Time {
+	return s.created
+}
+
+// Destroy clears the session and makes the client delete its cookie.
+func (s *Session) Destroy() {
+	clear(s.values)
+	s.destroyed = true
+}
+
+// Renew keeps the values but starts the session anew, with a new ID when a
+// store is used. Call it when the user logs in, so a session ID planted before
+// cannot be used to take over the logged in session.
+func (s *Session) Renew() {
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = ""
+	s.created = time.Time{}
+	s.changed = true
+}
+
+// sessionState is what the session cookie carries.
+type sessionState struct {
+	ID       string            `json:"id,omitempty"`
+	Values   map[string]string `json:"v,omitempty"`
+	Created  int64             `json:"c"`
+	Accessed int64             `json:"a"`
+}
+
+// Sessions keeps per-client session values in a cookie that is authenticated
+// with HMAC-SHA256 or encrypted with AES-GCM, or, if Store is set, in the store
+// with the cookie holding the session ID. Wrap handlers with Middleware and get
+// the session of a request with Session.
+type Sessions struct {
+	// Cookie holds the name and attributes of the session cookie. Its Value,
+	// Expires and MaxAge are ignored.
+	Cookie cookie.Cookie
+	// IdleTimeout ends sessions not used for that long, MaxAge sessions older
+	// than that. Zero disables the limit.
+	IdleTimeout time.Duration
+	MaxAge      time.Duration
+	// Store, if set, keeps the session values on the server.
+	Store SessionStore
+
+	keys  [][]byte
+	aeads []cipher.AEAD
+	now   func() time.Time
+
+	mu     sync.Mutex
+	active map[*request.Request]*Session
+}
+
+func newSessions(keys [][]byte) *Sessions {
+	return &Sessions{
+		Cookie: cookie.Cookie{
+			Name:     "session",
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: cookie.SameSiteLax,
+		},
+		IdleTimeout: 30 * time.Minute,
+		MaxAge:      24 * time.Hour,
+		keys:        keys,
+		now:         time.Now,
+		active:      make(map[*request.Request]*Session),
+	}
+}
+
+// NewSignedSessions returns Sessions whose cookies are authenticated with
+// HMAC-SHA256, so clients can read but not alter them. Cookies are signed with
+// the first key and accepted if signed with any of them: rotate keys by adding
+// a new one in front and dropping the last once its cookies have expired. Keys
+// must be at least 32 bytes long.
+func NewSignedSessions(keys ...[]byte) (*Sessions, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w - no keys", ErrInvalidSessionKey)
+	}
+	for _, key := range keys {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("%w - HMAC keys need at least 32 bytes, got %d", ErrInvalidSessionKey, len(key))
+		}
+	}
+	return newSessions(keys), nil
+}
+
+// NewEncryptedSessions returns Sessions whose cookies are encrypted and
+// authenticated with AES-GCM, so clients can neither read nor alter them. Keys
+// rotate as with NewSignedSessions and must be 16, 24 or 32 bytes long.
+func NewEncryptedSessions(keys ...[]byte) (*Sessions, error) {
+	var aeads []cipher.AEAD
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w - no keys", ErrInvalidSessionKey)
+	}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w - %v", ErrInvalidSessionKey, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("%w - %v", ErrInvalidSessionKey, err)
+		}
+		aeads = append(aeads, aead)
+	}
+	s := newSessions(keys)
+	s.aeads = aeads
+	return s, nil
+}
+
+// seal protects the cookie payload. The cookie name is authenticated along
+// with it, so a value cannot be moved to another cookie.
+func (s *Sessions) seal(payload []byte) ([]byte, error) {
+	if s.aeads != nil {
+		nonce := make([]byte, s.aeads[0].NonceSize())
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+		return s.aeads[0].Seal(nonce, nonce, payload, []byte(s.Cookie.Name)), nil
+	}
+	mac := hmac.New(sha256.New, s.keys[0])
+	mac.Write([]byte(s.Cookie.Name + "="))
+	mac.Write(payload)
+	return mac.Sum(payload), nil
+}
+
+// open returns the payload of a sealed cookie, trying every key.
+func (s *Sessions) open(sealed []byte) ([]byte, error) {
+	if s.aeads != nil {
+		for _, aead := range s.aeads {
+			if len(sealed) < aead.NonceSize() {
+				break
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+			payload, err := aead.Open(nil, nonce, ciphertext, []byte(s.Cookie.Name))
+			if err == nil {
+				return payload, nil
+			}
+		}
+		return nil, ErrInvalidSession
+	}
+	if len(sealed) < sha256.Size {
+		return nil, ErrInvalidSession
+	}
+	payload, sum := sealed[:len(sealed)-sha256.Size], sealed[len(sealed)-sha256.Size:]
+	for _, key := range s.keys {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s.Cookie.Name + "="))
+		mac.Write(payload)
+		if hmac.Equal(mac.Sum(nil), sum) {
+			return payload, nil
+		}
+	}
+	return nil, ErrInvalidSession
+}
+
+// decode returns the session state in a cookie value if it is authentic and
+// has not expired.
+func (s *Sessions) decode(value string, now time.Time) (sessionState, error) {
+	var (
+		err     error
+		payload []byte
+		sealed  []byte
+		st      sessionState
+	)
+	sealed, err = base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		payload, err = s.open(sealed)
+	}
+	if err == nil {
+		err = json.Unmarshal(payload, &st)
+	}
+	if err != nil {
+		return st, fmt.Errorf("%w - %v", ErrInvalidSession, err)
+	}
+	if s.MaxAge > 0 && now.Sub(time.Unix(st.Created, 0)) > s.MaxAge {
+		return st, fmt.Errorf("%w - session older than %v", ErrInvalidSession, s.MaxAge)
+	}
+	if s.IdleTimeout > 0 && now.Sub(time.Unix(st.Accessed, 0)) > s.IdleTimeout {
+		return st, fmt.Errorf("%w - session idle for over %v", ErrInvalidSession, s.IdleTimeout)
+	}
+	return st, nil
+}
+
+// load returns the session of req, a new one if it has no valid session cookie.
+func (s *Sessions) load(req *request.Request, now time.Time) (*Session, error) {
+	var (
+		c      *cookie.Cookie
+		err    error
+		found  bool
+		sess   = &Session{values: make(map[string]string), created: now, isNew: true}
+		st     sessionState
+		values map[string]string
+	)
+	c, err = req.Cookie(s.Cookie.Name)
+	if err != nil {
+		return sess, nil
+	}
+	st, err = s.decode(c.Value, now)
+	if err != nil {
+		logger.Debug("session cookie ignored", "error", err)
+		return sess, nil
+	}
+	values = st.Values
+	if s.Store != nil {
+		if st.ID == "" {
+			return sess, nil
+		}
+		values, found, err = s.Store.Load(st.ID)
+		if err != nil || !found {
+			return sess, err
+		}
+	}
+	if values == nil {
+		values = make(map[string]string)
+	}
+	return &Session{
+		id:      st.ID,
+		values:  values,
+		created: time.Unix(st.Created, 0),
+	}, nil
+}
+
+// save sets the session cookie, if needed, when the response headers are
+// written.
+func (s *Sessions) save(w *response.Writer, sess *Session, now time.Time) error {
+	var (
+		c       cookie.Cookie = s.Cookie
+		err     error
+		expiry  time.Time
+		payload []byte
+		sealed  []byte
+		st      sessionState
+	)
+	c.Expires, c.MaxAge = time.Time{}, 0
+	if s.Store != nil && sess.oldID != "" {
+		err = s.Store.Delete(sess.oldID)
+		if err != nil {
+			return err
+		}
+	}
+	if sess.destroyed {
+		if s.Store != nil && sess.id != "" {
+			err = s.Store.Delete(sess.id)
+			if err != nil {
+				return err
+			}
+		}
+		if sess.isNew {
+			return nil
+		}
+		c.MaxAge = -1
+		return w.SetCookie(&c)
+	}
+	// an unused new session is not worth a cookie, and an unchanged one only
+	// needs refreshing to extend its idle timeout
+	if !sess.changed && (sess.isNew || s.IdleTimeout == 0) {
+		return nil
+	}
+	if sess.created.IsZero() {
+		sess.created = now
+	}
+	st = sessionState{Created: sess.created.Unix(), Accessed: now.Unix()}
+	if s.MaxAge > 0 {
+		expiry = sess.created.Add(s.MaxAge)
+	}
+	if s.IdleTimeout > 0 && (expiry.IsZero() || now.Add(s.IdleTimeout).Before(expiry)) {
+		expiry = now.Add(s.IdleTimeout)
+	}
+	if s.Store != nil {
+		if sess.id == "" {
+			sess.id, err = newSessionID()
+			if err != nil {
+				return err
+			}
+		}
+		st.ID = sess.id
+		err = s.Store.Save(sess.id, sess.values, expiry)
+		if err != nil {
+			return err
+		}
+	} else {
+		st.Values = sess.values
+	}
+	payload, err = json.Marshal(st)
+	if err == nil {
+		sealed, err = s.seal(payload)
+	}
+	if err != nil {
+		return err
+	}
+	c.Value = base64.RawURLEncoding.EncodeToString(sealed)
+	if !expiry.IsZero() {
+		c.MaxAge = max(int(expiry.Sub(now).Seconds()), 1)
+	}
+	if len(c.String()) > maxCookieSize {
+		return fmt.Errorf("Error: session cookie of %d bytes exceeds %d, use a SessionStore", len(c.String()), maxCookieSize)
+	}
+	return w.SetCookie(&c)
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Session returns the session of a request passed to a handler wrapped by
+// Middleware, or nil for any other request.
+func (s *Sessions) Session(req *request.Request) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active[req]
+}
+
+// Middleware returns a handler loading the session of each request for next
+// and saving it as a cookie when next writes the response headers.
+func (s *Sessions) Middleware(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) error {
+		now := s.now()
+		sess, err := s.load(req, now)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.active[req] = sess
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			delete(s.active, req)
+			s.mu.Unlock()
+		}()
+		w.OnWriteHeaders(func(w *response.Writer) error {
+			return s.save(w, sess, now)
+		})
+		return next(w, req)
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	sessionKey1 = bytes.Repeat([]byte("k"), 32)
+	sessionKey2 = bytes.Repeat([]byte("K"), 32)
+)
+
+// counterHandler counts the requests of a session; "/logout" destroys it and
+// "/login" renews it.
+func counterHandler(s *Sessions) Handler {
+	return s.Middleware(func(w *response.Writer, req *request.Request) error {
+		sess := s.Session(req)
+		switch req.RequestLine.RequestTarget {
+		case "/logout":
+			sess.Destroy()
+		case "/login":
+			sess.Renew()
+			sess.Set("user", "gopher")
+		case "/read":
+		default:
+			n, _ := strconv.Atoi(sess.Get("count"))
+			sess.Set("count", strconv.Itoa(n+1))
+		}
+		body := sess.Get("count")
+		err := w.WriteStatusLine(response.StatusCode200)
+		if err == nil {
+			err = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		}
+		if err == nil {
+			_, err = w.WriteBody([]byte(body))
+		}
+		return err
+	})
+}
+
+// sessionRequest sends a request with the session cookie value, if any, and
+// returns the response body and the new session cookie.
+func sessionRequest(t *testing.T, handler Handler, target, value string) (string, *http.Cookie) {
+	t.Helper()
+	raw := "GET " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n"
+	if value != "" {
+		raw += "Cookie: theme=dark; session=" + value + "\r\n"
+	}
+	res, body := serveRaw(t, handler, raw+"\r\n")
+	for _, c := range res.Cookies() {
+		if c.Name == "session" {
+			return body, c
+		}
+	}
+	return body, nil
+}
+
+func TestSessions(t *testing.T) {
+	var (
+		body string
+		c    *http.Cookie
+		err  error
+		s    *Sessions
+	)
+	s, err = NewSignedSessions(sessionKey1)
+	require.NoError(t, err)
+	h := counterHandler(s)
+
+	// Test: A new session is started and its values kept across requests
+	body, c = sessionRequest(t, h, "/", "")
+	assert.Equal(t, "1", body)
+	require.NotNil(t, c)
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, "/", c.Path)
+	assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+	assert.Equal(t, 1800, c.MaxAge)
+	body, c = sessionRequest(t, h, "/", c.Value)
+	assert.Equal(t, "2", body)
+	require.NotNil(t, c)
+
+	// Test: Signed cookies can be read but not altered
+	payload, err := base64.RawURLEncoding.DecodeString(c.Value)
+	require.NoError(t, err)
+	assert.Contains(t, string(payload), `"count":"2"`)
+	forged := base64.RawURLEncoding.EncodeToString(bytes.Replace(payload, []byte(`"count":"2"`), []byte(`"count":"9"`), 1))
+	body, _ = sessionRequest(t, h, "/", forged)
+	assert.Equal(t, "1", body)
+	body, _ = sessionRequest(t, h, "/", "garbage!")
+	assert.Equal(t, "1", body)
+
+	// Test: Sessions that were only read are refreshed, unused new ones get no cookie
+	body, c = sessionRequest(t, h, "/read", c.Value)
+	assert.Equal(t, "2", body)
+	require.NotNil(t, c)
+	_, c2 := sessionRequest(t, h, "/read", "")
+	assert.Nil(t, c2)
+
+	// Test: Destroying a session deletes the cookie
+	_, c2 = sessionRequest(t, h, "/logout", c.Value)
+	require.NotNil(t, c2)
+	assert.Equal(t, -1, c2.MaxAge)
+	assert.Empty(t, c2.Value)
+
+	// Test: Sessions idle for too long expire
+	s.now = func() time.Time { return time.Now().Add(31 * time.Minute) }
+	body, _ = sessionRequest(t, h, "/", c.Value)
+	assert.Equal(t, "1", body)
+
+	// Test: Sessions expire after MaxAge even when in use
+	s.IdleTimeout = 0
+	s.now = time.Now
+	_, c = sessionRequest(t, h, "/", "")
+	assert.Equal(t, 86400, c.MaxAge)
+	s.now = func() time.Time { return time.Now().Add(23 * time.Hour) }
+	body, c2 = sessionRequest(t, h, "/", c.Value)
+	assert.Equal(t, "2", body)
+	assert.InDelta(t, 3600, c2.MaxAge, 2, "the cookie expires with the session")
+	s.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	body, _ = sessionRequest(t, h, "/", c.Value)
+	assert.Equal(t, "1", body)
+
+	// Test: No session outside the middleware
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Nil(t, s.Session(req))
+}
+
+func TestSessionKeys(t *testing.T) {
+	var (
+		body    string
+		c       *http.Cookie
+		err     error
+		old     *Sessions
+		rotated *Sessions
+		s       *Sessions
+	)
+	// Test: Invalid keys
+	_, err = NewSignedSessions()
+	assert.ErrorIs(t, err, ErrInvalidSessionKey)
+	_, err = NewSignedSessions([]byte("short"))
+	assert.ErrorIs(t, err, ErrInvalidSessionKey)
+	_, err = NewEncryptedSessions(bytes.Repeat([]byte("k"), 20))
+	assert.ErrorIs(t, err, ErrInvalidSessionKey)
+
+	for _, tt := range []struct {
+		name string
+		new  func(keys ...[]byte) (*Sessions, error)
+	}{
+		{"signed", NewSignedSessions},
+		{"encrypted", NewEncryptedSessions},
+	} {
+		old, err = tt.new(sessionKey1)
+		require.NoError(t, err)
+		_, c = sessionRequest(t, counterHandler(old), "/", "")
+		require.NotNil(t, c, tt.name)
+
+		// Test: Cookies made with an older key are accepted after rotation
+		rotated, err = tt.new(sessionKey2, sessionKey1)
+		require.NoError(t, err)
+		body, c = sessionRequest(t, counterHandler(rotated), "/", c.Value)
+		assert.Equal(t, "2", body, tt.name)
+
+		// Test: New cookies are made with the first key
+		body, _ = sessionRequest(t, counterHandler(old), "/", c.Value)
+		assert.Equal(t, "1", body, tt.name)
+		s, err = tt.new(sessionKey2)
+		require.NoError(t, err)
+		body, _ = sessionRequest(t, counterHandler(s), "/", c.Value)
+		assert.Equal(t, "3", body, tt.name)
+
+		// Test: Cookies are bound to their name
+		s.Cookie.Name = "other"
+		_, oc := serveRaw(t, counterHandler(s), "GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: other="+c.Value+"\r\n\r\n")
+		assert.Equal(t, "1", oc, tt.name)
+	}
+
+	// Test: Encrypted cookies cannot be read
+	s, err = NewEncryptedSessions(sessionKey1)
+	require.NoError(t, err)
+	_, c = sessionRequest(t, counterHandler(s), "/", "")
+	payload, err := base64.RawURLEncoding.DecodeString(c.Value)
+	require.NoError(t, err)
+	assert.NotContains(t, string(payload), "count")
+}
+
+func TestSessionStore(t *testing.T) {
+	var (
+		body  string
+		c, c2 *http.Cookie
+		err   error
+		s     *Sessions
+		store = NewMemoryStore()
+	)
+	s, err = NewSignedSessions(sessionKey1)
+	require.NoError(t, err)
+	s.Store = store
+	h := counterHandler(s)
+
+	// Test: The cookie carries the session ID and the values stay on the server
+	body, c = sessionRequest(t, h, "/", "")
+	assert.Equal(t, "1", body)
+	payload, err := base64.RawURLEncoding.DecodeString(c.Value)
+	require.NoError(t, err)
+	assert.NotContains(t, string(payload), "count")
+	assert.Equal(t, 1, store.Len())
+	body, c = sessionRequest(t, h, "/", c.Value)
+	assert.Equal(t, "2", body)
+
+	// Test: Renewing gives the session a new ID and keeps its values
+	_, c2 = sessionRequest(t, h, "/login", c.Value)
+	assert.NotEqual(t, c.Value, c2.Value)
+	assert.Equal(t, 1, store.Len())
+	body, _ = sessionRequest(t, h, "/read", c.Value)
+	assert.Empty(t, body, "the old ID is gone")
+	body, _ = sessionRequest(t, h, "/read", c2.Value)
+	assert.Equal(t, "2", body)
+
+	// Test: Destroying a session removes it from the store
+	_, c = sessionRequest(t, h, "/logout", c2.Value)
+	assert.Equal(t, -1, c.MaxAge)
+	assert.Equal(t, 0, store.Len())
+	body, _ = sessionRequest(t, h, "/", c2.Value)
+	assert.Equal(t, "1", body)
+
+	// Test: Expired sessions are not loaded
+	require.NoError(t, store.Save("old", map[string]string{"count": "5"}, time.Now().Add(-time.Second)))
+	_, found, err := store.Load("old")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,250 @@
+// Package sse streams Server-Sent Events, the text/event-stream format read by
+// browsers' EventSource, as a chunked response body.
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+var ErrStreamClosed = errors.New("event stream closed")
+
+// DefaultKeepAlive is a keep-alive interval short enough for proxies not to
+// drop idle streams.
+const DefaultKeepAlive = 15 * time.Second
+
+// Event is a single event of a stream.
+type Event struct {
+	// Event is the event type, dispatched to listeners of that name. Empty
+	// means "message".
+	Event string
+	// ID becomes the last event ID of the client, sent back in Last-Event-ID
+	// when it reconnects.
+	ID string
+	// Data is the event payload. Each of its lines is sent as a data field;
+	// events without data only update the ID and retry time.
+	Data string
+	// Retry sets the time the client waits before reconnecting if the stream
+	// is lost. 0 leaves it unchanged.
+	Retry time.Duration
+}
+
+// format returns the fields of e ending with the blank line that dispatches
+// it.
+func (e Event) format() ([]byte, error) {
+	var (
+		b strings.Builder
+	)
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("Error: event type contains a line break - %q", e.Event)
+	}
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("Error: event ID contains a line break or NUL - %q", e.ID)
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	if e.Data != "" {
+		data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+// Stream writes events to a client as they happen. Its methods may be called
+// from several goroutines.
+type Stream struct {
+	// LastEventID is the Last-Event-ID of the request, the ID of the last
+	// event a reconnecting client received, so that the events it missed
+	// can be sent again.
+	LastEventID string
+
+	w    *response.Writer
+	mu   sync.Mutex
+	err  error // set once nothing more can be written
+	done chan struct{}
+}
+
+// NewStream answers req with the headers of an event stream and returns the
+// Stream writing its body. Unless keepAlive is 0, a comment is sent whenever
+// it elapses, which keeps intermediaries from timing out an idle stream.
+//
+// The connection is watched for the client closing it, which ends the stream
+// at once. Since that reads from the connection, the response is sent with
+// Connection: close; clients open a new connection for further requests
+// anyway while a stream is open. If w is not writing to a connection, a
+// client that has gone away is only noticed once writing to it fails.
+//
+// The handler must end the stream with Close before returning, typically once
+// Done is closed or it has no more events to send:
+//
+//	s, err := sse.NewStream(w, req, sse.DefaultKeepAlive)
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	for {
+//		select {
+//		case <-s.Done():
+//			return nil
+//		case ev := <-updates:
+//			s.Send(ev)
+//		}
+//	}
+func NewStream(w *response.Writer, req *request.Request, keepAlive time.Duration) (*Stream, error) {
+	var (
+		conn net.Conn = w.Conn()
+		err  error
+		h    headers.Headers
+	)
+	err = w.WriteStatusLine(response.StatusCode200)
+	if err == nil {
+		h = headers.NewHeaders()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Transfer-Encoding", "chunked")
+		if conn != nil {
+			h.Set("Connection", "close")
+		}
+		err = w.WriteHeaders(h)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.State = response.StateChunkedBody
+	s := &Stream{
+		LastEventID: req.Headers.Get("Last-Event-ID"),
+		w:           w,
+		done:        make(chan struct{}),
+	}
+	if conn != nil {
+		go s.watch(conn)
+	}
+	if keepAlive > 0 {
+		go s.keepAlive(keepAlive)
+	}
+	return s, nil
+}
+
+// watch ends the stream once conn can no longer be read, which happens when
+// the client closes it. Clients send nothing while a stream is open; anything
+// they do is discarded along with the connection.
+func (s *Stream) watch(conn net.Conn) {
+	var (
+		buf = make([]byte, 512)
+		err error
+	)
+	for err == nil {
+		_, err = conn.Read(buf)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.end(fmt.Errorf("%w - %v", ErrStreamClosed, err))
+	}
+}
+
+// keepAlive sends a comment every interval until the stream ends.
+func (s *Stream) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.write([]byte(": keep-alive\n\n"))
+		}
+	}
+}
+
+// end stops the stream with err. The caller must hold mu.
+func (s *Stream) end(err error) {
+	s.err = err
+	close(s.done)
+}
+
+// write sends p as a chunk straight away.
+func (s *Stream) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.w.WriteChunkedBody(p)
+	if err == nil {
+		_, err = s.w.Body.WriteTo(s.w.Writer)
+	}
+	if err != nil {
+		// the client is gone - nothing more can be written
+		s.end(fmt.Errorf("%w - %v", ErrStreamClosed, err))
+		return s.err
+	}
+	return nil
+}
+
+// Send writes e to the client.
+func (s *Stream) Send(e Event) error {
+	p, err := e.format()
+	if err != nil {
+		return err
+	}
+	return s.write(p)
+}
+
+// Comment writes a comment, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var (
+		b strings.Builder
+	)
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write([]byte(b.String()))
+}
+
+// Done returns a channel closed when the stream ends, because the client has
+// gone away or Close was called.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the response body. Clients reconnect after the retry time unless
+// answered with 204 No Content.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil
+	}
+	s.end(ErrStreamClosed)
+	s.w.State = response.StateChunkedBodyDone
+	_, err := s.w.WriteChunkedBodyDone()
+	if err == nil {
+		_, err = s.w.Body.WriteTo(s.w.Writer)
+	}
+	if err == nil {
+		err = s.w.WriteTrailers(nil)
+	}
+	return err
+}
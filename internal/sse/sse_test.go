@@ -0,0 +1,119 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+	"github.com/dragonicorn/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventFormat(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Data: "hello"}, "data: hello\n\n"},
+		{Event{Event: "update", ID: "42", Data: "{\"n\": 1}"}, "event: update\nid: 42\ndata: {\"n\": 1}\n\n"},
+		{Event{Data: "line one\nline two\r\nline three\rline four"}, "data: line one\ndata: line two\ndata: line three\ndata: line four\n\n"},
+		{Event{Data: "trailing\n"}, "data: trailing\ndata: \n\n"},
+		{Event{Retry: 2500 * time.Millisecond}, "retry: 2500\n\n"},
+		{Event{ID: "7"}, "id: 7\n\n"},
+	}
+	for _, tt := range tests {
+		got, err := tt.event.format()
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, string(got))
+	}
+
+	// Test: Fields that would break the stream
+	for _, e := range []Event{{Event: "a\nb"}, {ID: "1\r"}, {ID: "1\x00"}} {
+		_, err := e.format()
+		assert.Error(t, err, e)
+	}
+}
+
+// serve starts a server streaming with handler and sends it a GET request
+// with the extra header lines.
+func serve(t *testing.T, handler server.Handler, extra string) (net.Conn, *http.Response) {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = fmt.Fprintf(conn, "GET /events HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/event-stream\r\n%s\r\n", extra)
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return conn, res
+}
+
+func TestStream(t *testing.T) {
+	// Test: Events are flushed as they are sent, with keep-alives in between
+	next := make(chan struct{})
+	_, res := serve(t, func(w *response.Writer, req *request.Request) error {
+		s, err := NewStream(w, req, 50*time.Millisecond)
+		if err != nil {
+			return err
+		}
+		s.Send(Event{Retry: time.Second, Data: "resuming after " + s.LastEventID})
+		<-next
+		s.Comment("multi\nline")
+		s.Send(Event{Event: "tick", ID: "42", Data: "a\nb"})
+		return s.Close()
+	}, "Last-Event-ID: 41\r\n")
+	defer res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", res.Header.Get("Cache-Control"))
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	br := bufio.NewReader(res.Body)
+	for _, want := range []string{"retry: 1000\n", "data: resuming after 41\n", "\n"} {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ": keep-alive\n", line, "sent while the stream is idle")
+	close(next)
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err, "the chunked body is terminated")
+	assert.Contains(t, string(rest), ": multi\n: line\n\nevent: tick\nid: 42\ndata: a\ndata: b\n\n")
+}
+
+func TestDisconnect(t *testing.T) {
+	result := make(chan error, 1)
+	conn, res := serve(t, func(w *response.Writer, req *request.Request) error {
+		s, err := NewStream(w, req, 0)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-s.Done():
+			result <- s.Send(Event{Data: "too late"})
+			s.Close()
+		case <-time.After(time.Second):
+			result <- fmt.Errorf("Error: disconnect not detected")
+		}
+		return nil
+	}, "")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.True(t, res.Close, "the connection is not reused after the stream")
+
+	// Test: The stream ends once the client has gone away, without
+	// keep-alives having to fail first
+	conn.Close()
+	assert.ErrorIs(t, <-result, ErrStreamClosed)
+}
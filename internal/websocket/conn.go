@@ -0,0 +1,476 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrProtocol        = errors.New("WebSocket protocol violation")
+	ErrMessageTooLarge = errors.New("WebSocket message exceeds read limit")
+	ErrClosed          = errors.New("WebSocket connection is closing")
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes of RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005 // never sent, reported for close frames without a code
+	CloseAbnormal        = 1006 // never sent, reported for connections lost without a close frame
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// closeTimeout bounds the wait for the peer's close frame in Close.
+const closeTimeout = 5 * time.Second
+
+// CloseError is returned by ReadMessage once the connection has been closed by
+// a close frame, or lost without one.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("WebSocket closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("WebSocket closed with code %d - %s", e.Code, e.Reason)
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// Conn is a WebSocket connection. One goroutine may read messages while
+// another writes them; Ping, WriteMessage and Close may be called
+// concurrently.
+type Conn struct {
+	conn         net.Conn
+	br           *bufio.Reader
+	client       bool // frames are masked when sent by the client only
+	readLimit    int64
+	fragmentSize int
+	compress     bool
+	subprotocol  string
+
+	rmu     sync.Mutex // held while reading a message
+	readErr error      // set once no more messages can be read
+
+	wmu       sync.Mutex // serialises frames
+	closeSent bool
+	deflater  *deflater
+}
+
+// newConn returns a Conn for the side of a connection past the opening
+// handshake, with br holding anything read beyond it.
+func newConn(conn net.Conn, br *bufio.Reader, client bool, u *Upgrader) *Conn {
+	c := &Conn{
+		conn:         conn,
+		br:           br,
+		client:       client,
+		readLimit:    u.ReadLimit,
+		fragmentSize: u.FragmentSize,
+	}
+	if c.readLimit <= 0 {
+		c.readLimit = DefaultReadLimit
+	}
+	return c
+}
+
+// Subprotocol returns the application protocol selected in the handshake, if
+// any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the time by which the next message must be read, after
+// which ReadMessage fails and the connection can no longer be read.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	opcode byte
+	length int64
+	masked bool
+	mask   [4]byte
+}
+
+// readFrameHeader reads and checks the header of the next frame.
+func (c *Conn) readFrameHeader() (frameHeader, error) {
+	var (
+		b   [8]byte
+		err error
+		h   frameHeader
+	)
+	_, err = io.ReadFull(c.br, b[:2])
+	if err != nil {
+		return h, err
+	}
+	h.fin = b[0]&0x80 != 0
+	h.rsv1 = b[0]&0x40 != 0
+	h.opcode = b[0] & 0x0f
+	h.masked = b[1]&0x80 != 0
+	h.length = int64(b[1] & 0x7f)
+	switch h.length {
+	case 126:
+		_, err = io.ReadFull(c.br, b[:2])
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		_, err = io.ReadFull(c.br, b[:8])
+		h.length = int64(binary.BigEndian.Uint64(b[:8]))
+	}
+	if err == nil && h.masked {
+		_, err = io.ReadFull(c.br, h.mask[:])
+	}
+	if err != nil {
+		return h, err
+	}
+	control := h.opcode&0x8 != 0
+	switch {
+	case b[0]&0x30 != 0:
+		return h, fmt.Errorf("%w - reserved bits set", ErrProtocol)
+	case h.rsv1 && (!c.compress || control):
+		return h, fmt.Errorf("%w - unexpected compressed frame", ErrProtocol)
+	case h.opcode > opBinary && h.opcode < opClose, h.opcode > opPong:
+		return h, fmt.Errorf("%w - unknown opcode %#x", ErrProtocol, h.opcode)
+	case control && (!h.fin || h.length > 125):
+		return h, fmt.Errorf("%w - fragmented or oversized control frame", ErrProtocol)
+	case h.length < 0:
+		return h, fmt.Errorf("%w - invalid frame length", ErrProtocol)
+	case h.masked == c.client:
+		return h, fmt.Errorf("%w - frame masking", ErrProtocol)
+	}
+	return h, nil
+}
+
+// readPayload reads the payload of the frame with header h.
+func (c *Conn) readPayload(h frameHeader) ([]byte, error) {
+	p := make([]byte, h.length)
+	_, err := io.ReadFull(c.br, p)
+	if err != nil {
+		return nil, err
+	}
+	if h.masked {
+		maskBytes(h.mask, p)
+	}
+	return p, nil
+}
+
+func maskBytes(key [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= key[i&3]
+	}
+}
+
+// fail ends reading with err and closes the connection, after a close frame
+// with code unless code is 0.
+func (c *Conn) fail(code int, err error) error {
+	if code != 0 {
+		c.writeClose(code, "")
+	}
+	c.conn.Close()
+	c.readErr = err
+	return err
+}
+
+// ReadMessage reads the next data message, answering pings and reassembling
+// fragmented messages. Once the peer sends a close frame the close handshake
+// is completed and a *CloseError is returned; after protocol violations by the
+// peer the connection is closed with the appropriate code. Every error is
+// final: later calls return it again.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	return c.readMessage()
+}
+
+// readMessage reads the next data message. The caller must hold rmu.
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		compressed bool
+		err        error
+		h          frameHeader
+		msg        []byte
+		p          []byte
+		typ        MessageType
+	)
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	for {
+		h, err = c.readFrameHeader()
+		if err == nil && h.opcode&0x8 == 0 && h.length > c.readLimit-int64(len(msg)) {
+			return 0, nil, c.fail(CloseMessageTooBig, fmt.Errorf("%w - %d bytes", ErrMessageTooLarge, c.readLimit))
+		}
+		if err == nil {
+			p, err = c.readPayload(h)
+		}
+		if errors.Is(err, ErrProtocol) {
+			return 0, nil, c.fail(CloseProtocolError, err)
+		} else if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				err = &CloseError{Code: CloseAbnormal}
+			}
+			return 0, nil, c.fail(0, err)
+		}
+		switch h.opcode {
+		case opPing:
+			err = c.writeControl(opPong, p)
+			if err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, c.fail(0, err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.closeReceived(p)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("%w - data frame inside a fragmented message", ErrProtocol))
+			}
+			typ = MessageType(h.opcode)
+			compressed = h.rsv1
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("%w - continuation frame outside a message", ErrProtocol))
+			}
+			if h.rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("%w - compressed continuation frame", ErrProtocol))
+			}
+		}
+		msg = append(msg, p...)
+		if h.fin {
+			break
+		}
+	}
+	if compressed {
+		msg, err = inflate(msg, c.readLimit)
+		if errors.Is(err, ErrMessageTooLarge) {
+			return 0, nil, c.fail(CloseMessageTooBig, err)
+		} else if err != nil {
+			return 0, nil, c.fail(CloseInvalidPayload, err)
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, c.fail(CloseInvalidPayload, fmt.Errorf("%w - text message is not valid UTF-8", ErrProtocol))
+	}
+	return typ, msg, nil
+}
+
+// closeReceived completes the close handshake started by the peer with the
+// close frame payload p, or ends the one started by Close.
+func (c *Conn) closeReceived(p []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	if len(p) == 1 {
+		return c.fail(CloseProtocolError, fmt.Errorf("%w - close frame of 1 byte", ErrProtocol))
+	}
+	if len(p) >= 2 {
+		ce.Code = int(binary.BigEndian.Uint16(p))
+		ce.Reason = string(p[2:])
+		if !validCloseCode(ce.Code) {
+			return c.fail(CloseProtocolError, fmt.Errorf("%w - close code %d", ErrProtocol, ce.Code))
+		}
+		if !utf8.ValidString(ce.Reason) {
+			return c.fail(CloseInvalidPayload, fmt.Errorf("%w - close reason is not valid UTF-8", ErrProtocol))
+		}
+	}
+	// echo the code unless the handshake was ours
+	code := ce.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	c.writeClose(code, "")
+	return c.fail(0, ce)
+}
+
+// writeFrame sends a frame in a single write. The caller must hold wmu.
+func (c *Conn) writeFrame(opcode byte, fin, rsv1 bool, p []byte) error {
+	var (
+		b   []byte
+		err error
+		key [4]byte
+	)
+	b = make([]byte, 2, 14+len(p))
+	b[0] = opcode
+	if fin {
+		b[0] |= 0x80
+	}
+	if rsv1 {
+		b[0] |= 0x40
+	}
+	switch {
+	case len(p) < 126:
+		b[1] = byte(len(p))
+	case len(p) <= 0xffff:
+		b[1] = 126
+		b = binary.BigEndian.AppendUint16(b, uint16(len(p)))
+	default:
+		b[1] = 127
+		b = binary.BigEndian.AppendUint64(b, uint64(len(p)))
+	}
+	if c.client {
+		b[1] |= 0x80
+		_, err = rand.Read(key[:])
+		if err != nil {
+			return err
+		}
+		b = append(b, key[:]...)
+	}
+	b = append(b, p...)
+	if c.client {
+		maskBytes(key, b[len(b)-len(p):])
+	}
+	_, err = c.conn.Write(b)
+	return err
+}
+
+// writeControl sends a control frame.
+func (c *Conn) writeControl(opcode byte, p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	return c.writeFrame(opcode, true, false, p)
+}
+
+// writeClose sends a close frame with code and reason, unless one was sent
+// already.
+func (c *Conn) writeClose(code int, reason string) error {
+	var (
+		p []byte
+	)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	c.closeSent = true
+	p = binary.BigEndian.AppendUint16(nil, uint16(code))
+	// control frames carry at most 125 bytes
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	p = append(p, reason...)
+	return c.writeFrame(opClose, true, false, p)
+}
+
+// Ping sends a ping frame with up to 125 bytes of application data. The peer
+// answers with a pong, which ReadMessage consumes.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return fmt.Errorf("Error: ping data of %d bytes exceeds 125", len(data))
+	}
+	return c.writeControl(opPing, data)
+}
+
+// WriteMessage sends a data message, compressed if permessage-deflate was
+// negotiated. Text messages must be valid UTF-8.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	var (
+		err    error
+		n      int
+		opcode byte = byte(typ)
+		rsv1   bool
+	)
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("Error: invalid message type %d", typ)
+	}
+	if typ == TextMessage && !utf8.Valid(data) {
+		return fmt.Errorf("Error: text message is not valid UTF-8")
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if c.compress {
+		if c.deflater == nil {
+			c.deflater = newDeflater()
+		}
+		data, err = c.deflater.deflate(data)
+		if err != nil {
+			return err
+		}
+		rsv1 = true
+	}
+	for {
+		n = len(data)
+		if c.fragmentSize > 0 && n > c.fragmentSize {
+			n = c.fragmentSize
+		}
+		err = c.writeFrame(opcode, n == len(data), rsv1, data[:n])
+		if err != nil || n == len(data) {
+			return err
+		}
+		data = data[n:]
+		opcode, rsv1 = opContinuation, false
+	}
+}
+
+// Close starts the close handshake with code and reason, or completes it if
+// the peer started it, and closes the connection. Unless another goroutine is
+// in ReadMessage, which then receives the peer's close frame, Close waits for
+// it, discarding any messages received meanwhile.
+func (c *Conn) Close(code int, reason string) error {
+	if !validCloseCode(code) {
+		return fmt.Errorf("Error: invalid close code %d", code)
+	}
+	err := c.writeClose(code, reason)
+	if err != nil && !errors.Is(err, ErrClosed) {
+		c.conn.Close()
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	if c.rmu.TryLock() {
+		for c.readErr == nil {
+			c.readMessage()
+		}
+		c.rmu.Unlock()
+	}
+	return nil
+}
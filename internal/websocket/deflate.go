@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// deflateTail ends the deflate data of a message: senders remove the empty
+// stored block ending it, and a final empty block lets the reader reach EOF.
+const deflateTail = "\x00\x00\xff\xff\x01\x00\x00\xff\xff"
+
+// deflater compresses messages for permessage-deflate without context
+// takeover, reusing one compressor.
+type deflater struct {
+	buf bytes.Buffer
+	fw  *flate.Writer
+}
+
+func newDeflater() *deflater {
+	d := &deflater{}
+	// the level is valid, so NewWriter cannot fail
+	d.fw, _ = flate.NewWriter(&d.buf, flate.BestSpeed)
+	return d
+}
+
+// deflate returns the payload of a compressed message holding p. The result
+// is only valid until the next call.
+func (d *deflater) deflate(p []byte) ([]byte, error) {
+	d.buf.Reset()
+	d.fw.Reset(&d.buf)
+	_, err := d.fw.Write(p)
+	if err == nil {
+		err = d.fw.Flush()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error compressing WebSocket message: %v", err)
+	}
+	return bytes.TrimSuffix(d.buf.Bytes(), []byte(deflateTail[:4])), nil
+}
+
+// inflate decompresses the payload of a compressed message, failing with
+// ErrMessageTooLarge if it holds more than limit bytes.
+func inflate(p []byte, limit int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(p), strings.NewReader(deflateTail)))
+	defer fr.Close()
+	msg, err := io.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("Error decompressing WebSocket message: %v", err)
+	}
+	if int64(len(msg)) > limit {
+		return nil, fmt.Errorf("%w - %d bytes", ErrMessageTooLarge, limit)
+	}
+	return msg, nil
+}
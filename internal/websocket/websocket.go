@@ -0,0 +1,178 @@
+// Package websocket implements the WebSocket protocol of RFC 6455 on
+// connections taken over from the server, with the permessage-deflate
+// extension of RFC 7692.
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/dragonicorn/httpfromtcp/internal/headers"
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+)
+
+var ErrBadHandshake = errors.New("bad WebSocket handshake")
+
+// DefaultReadLimit is the largest message read when Upgrader.ReadLimit is 0.
+const DefaultReadLimit = 1 << 20
+
+// acceptGUID is appended to the client's key to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// deflateResponse accepts a permessage-deflate offer. Compressing every
+// message on its own keeps no state between messages on either side.
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// Upgrader performs the server side of the opening handshake.
+type Upgrader struct {
+	// Subprotocols are the application protocols supported, in order of
+	// preference. The first one the client also lists is selected.
+	Subprotocols []string
+	// CheckOrigin reports whether the request may be upgraded, to keep other
+	// sites' pages from opening connections with the user's cookies. If nil,
+	// requests whose Origin names a host other than the Host field are
+	// refused.
+	CheckOrigin func(req *request.Request) bool
+	// ReadLimit is the largest message accepted, in bytes once decompressed.
+	// Larger messages close the connection with CloseMessageTooBig. 0 means
+	// DefaultReadLimit.
+	ReadLimit int64
+	// FragmentSize splits messages written into frames carrying at most this
+	// many bytes. 0 sends every message in a single frame.
+	FragmentSize int
+	// EnableCompression accepts permessage-deflate from clients offering it.
+	EnableCompression bool
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// sameOrigin reports whether the request has no Origin field or one naming
+// the host in its Host field.
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Headers.Get("Host"))
+}
+
+// acceptsDeflate reports whether one of the permessage-deflate offers in the
+// Sec-WebSocket-Extensions fields of h can be accepted.
+func acceptsDeflate(h headers.Headers) bool {
+	for _, offer := range h.List("Sec-WebSocket-Extensions") {
+		params := strings.Split(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+			continue
+		}
+		ok := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(param, "=")
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				// the flate package always compresses with a 32KB window
+				ok = ok && strings.Trim(strings.TrimSpace(value), `"`) == "15"
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// refuse answers a request that cannot be upgraded.
+func refuse(w *response.Writer, statusCode response.StatusCode, extra headers.Headers, reason string) error {
+	err := w.WriteError(statusCode, extra)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w - %s", ErrBadHandshake, reason)
+}
+
+// Upgrade completes the opening handshake for req and takes the connection
+// over from the server. If req is not a valid WebSocket request, Upgrade
+// answers it itself and returns an error wrapping ErrBadHandshake, which the
+// handler can return; the connection is then closed by the server.
+//
+// The handler owns the returned connection and must close it.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	var (
+		c        *Conn
+		compress bool
+		err      error
+		h        headers.Headers
+		key      []byte
+		protocol string
+	)
+	if req.RequestLine.Method != "GET" {
+		return nil, refuse(w, response.StatusCode405, headers.Headers{{Name: "Allow", Value: "GET"}}, "method "+req.RequestLine.Method)
+	}
+	if !req.Headers.HasToken("Connection", "upgrade") || !req.Headers.HasToken("Upgrade", "websocket") {
+		return nil, refuse(w, response.StatusCode426, headers.Headers{{Name: "Upgrade", Value: "websocket"}, {Name: "Connection", Value: "Upgrade"}}, "not an upgrade request")
+	}
+	if req.Headers.Get("Sec-WebSocket-Version") != "13" {
+		return nil, refuse(w, response.StatusCode426, headers.Headers{{Name: "Sec-WebSocket-Version", Value: "13"}}, "version "+req.Headers.Get("Sec-WebSocket-Version"))
+	}
+	key, err = base64.StdEncoding.DecodeString(req.Headers.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return nil, refuse(w, response.StatusCode400, nil, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, refuse(w, response.StatusCode403, nil, "origin "+req.Headers.Get("Origin"))
+	}
+	offered := req.Headers.List("Sec-WebSocket-Protocol")
+	for _, p := range u.Subprotocols {
+		if slices.Contains(offered, p) {
+			protocol = p
+			break
+		}
+	}
+	compress = u.EnableCompression && acceptsDeflate(req.Headers)
+
+	h = headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(req.Headers.Get("Sec-WebSocket-Key")))
+	if protocol != "" {
+		h.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	if compress {
+		h.Set("Sec-WebSocket-Extensions", deflateResponse)
+	}
+	err = w.WriteStatusLine(response.StatusCode101)
+	if err == nil {
+		err = w.WriteHeaders(h)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	c = newConn(conn, bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn)), false, u)
+	c.compress = compress
+	c.subprotocol = protocol
+	return c, nil
+}
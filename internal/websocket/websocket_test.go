@@ -0,0 +1,322 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dragonicorn/httpfromtcp/internal/request"
+	"github.com/dragonicorn/httpfromtcp/internal/response"
+	"github.com/dragonicorn/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the example key and accept value of RFC 6455 section 1.3
+const (
+	testKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	testAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+// echoServer upgrades every request with u and echoes messages until the
+// connection closes, sending the final read error to errs if it is not nil.
+func echoServer(t *testing.T, u *Upgrader, errs chan<- error) string {
+	t.Helper()
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) error {
+		c, err := u.Upgrade(w, req)
+		if err != nil {
+			return err
+		}
+		for {
+			typ, msg, err := c.ReadMessage()
+			if err == nil {
+				err = c.WriteMessage(typ, msg)
+			}
+			if err != nil {
+				if errs != nil {
+					errs <- err
+				}
+				return nil
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return fmt.Sprintf("localhost:%d", s.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+// dial sends an opening handshake with the extra header lines to addr and
+// returns the client side of the connection if the server upgraded it.
+func dial(t *testing.T, addr, extra string) (*Conn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n%s\r\n", addr, testKey, extra)
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	if res.StatusCode != 101 {
+		return nil, res
+	}
+	c := newConn(conn, br, true, &Upgrader{})
+	c.compress = strings.HasPrefix(res.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	c.subprotocol = res.Header.Get("Sec-WebSocket-Protocol")
+	return c, res
+}
+
+// writeRaw sends a frame from the client side as given.
+func writeRaw(t *testing.T, c *Conn, opcode byte, fin bool, p []byte) {
+	t.Helper()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	require.NoError(t, c.writeFrame(opcode, fin, false, p))
+}
+
+// closeCode reads from c until it fails and returns the close code received.
+func closeCode(t *testing.T, c *Conn) int {
+	t.Helper()
+	var ce *CloseError
+	for {
+		_, _, err := c.ReadMessage()
+		if err != nil {
+			require.True(t, errors.As(err, &ce), err)
+			return ce.Code
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	addr := echoServer(t, &Upgrader{Subprotocols: []string{"v2.chat", "chat"}}, nil)
+
+	// Test: Accept key and subprotocol selection
+	assert.Equal(t, testAccept, acceptKey(testKey))
+	c, res := dial(t, addr, "Sec-WebSocket-Protocol: chat, v2.chat\r\nSec-WebSocket-Extensions: permessage-deflate\r\nOrigin: http://"+addr+"\r\n")
+	require.NotNil(t, c)
+	assert.Equal(t, "websocket", res.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", res.Header.Get("Connection"))
+	assert.Equal(t, testAccept, res.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "v2.chat", c.Subprotocol())
+	assert.Empty(t, res.Header.Get("Sec-WebSocket-Extensions"), "compression is not enabled")
+	c, _ = dial(t, addr, "Sec-WebSocket-Protocol: mqtt\r\n")
+	require.NotNil(t, c)
+	assert.Empty(t, c.Subprotocol())
+
+	// Test: Requests that cannot be upgraded
+	for _, tt := range []struct {
+		name   string
+		raw    string
+		status int
+		header string
+	}{
+		{"plain request", "GET /chat HTTP/1.1\r\nHost: " + addr + "\r\n\r\n", 426, "Upgrade"},
+		{"wrong method", "POST /chat HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nContent-Length: 0\r\n\r\n", 405, "Allow"},
+		{"old version", "GET /chat HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 8\r\n\r\n", 426, "Sec-WebSocket-Version"},
+		{"bad key", "GET /chat HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: c2hvcnQ=\r\nSec-WebSocket-Version: 13\r\n\r\n", 400, ""},
+		{"cross origin", "GET /chat HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\nOrigin: https://evil.example\r\n\r\n", 403, ""},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte(tt.raw))
+		require.NoError(t, err)
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.status, res.StatusCode, tt.name)
+		if tt.header != "" {
+			assert.NotEmpty(t, res.Header.Get(tt.header), tt.name)
+		}
+		conn.Close()
+	}
+}
+
+func TestMessages(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := echoServer(t, &Upgrader{FragmentSize: 1000}, errs)
+	c, _ := dial(t, addr, "")
+	require.NotNil(t, c)
+
+	// Test: Text and binary messages of every length encoding
+	for _, msg := range [][]byte{nil, []byte("héllo"), bytes.Repeat([]byte("a"), 125), bytes.Repeat([]byte{0, 1}, 200), bytes.Repeat([]byte("b"), 70000)} {
+		for _, typ := range []MessageType{TextMessage, BinaryMessage} {
+			require.NoError(t, c.WriteMessage(typ, msg))
+			gotType, got, err := c.ReadMessage()
+			require.NoError(t, err)
+			assert.Equal(t, typ, gotType)
+			assert.Equal(t, len(msg), len(got))
+			assert.True(t, bytes.Equal(msg, got))
+		}
+	}
+
+	// Test: Messages are written in fragments of FragmentSize
+	require.NoError(t, c.WriteMessage(BinaryMessage, bytes.Repeat([]byte("f"), 2500)))
+	var frames []frameHeader
+	for {
+		h, err := c.readFrameHeader()
+		require.NoError(t, err)
+		_, err = c.readPayload(h)
+		require.NoError(t, err)
+		frames = append(frames, h)
+		if h.fin {
+			break
+		}
+	}
+	require.Len(t, frames, 3)
+	assert.Equal(t, byte(opBinary), frames[0].opcode)
+	assert.Equal(t, byte(opContinuation), frames[2].opcode)
+	assert.Equal(t, int64(500), frames[2].length)
+
+	// Test: Fragmented messages are reassembled around control frames
+	writeRaw(t, c, opText, false, []byte("frag"))
+	writeRaw(t, c, opPing, true, []byte("are you there"))
+	writeRaw(t, c, opContinuation, false, []byte("men"))
+	writeRaw(t, c, opContinuation, true, []byte("ted"))
+	h, err := c.readFrameHeader()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opPong), h.opcode)
+	p, err := c.readPayload(h)
+	require.NoError(t, err)
+	assert.Equal(t, "are you there", string(p))
+	_, got, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "fragmented", string(got))
+
+	// Test: Pongs answering a ping are consumed by ReadMessage
+	require.NoError(t, c.Ping([]byte("1")))
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("after ping")))
+	_, got, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "after ping", string(got))
+	assert.Error(t, c.Ping(make([]byte, 126)))
+	assert.Error(t, c.WriteMessage(TextMessage, []byte{0xff}))
+
+	// Test: Close handshake started by the client
+	require.NoError(t, c.Close(CloseNormal, "bye"))
+	assert.Equal(t, &CloseError{Code: CloseNormal, Reason: "bye"}, <-errs)
+	_, _, err = c.ReadMessage()
+	assert.Equal(t, &CloseError{Code: CloseNormal}, err, "the server echoes the code")
+	assert.ErrorIs(t, c.WriteMessage(TextMessage, []byte("late")), ErrClosed)
+}
+
+func TestServerClose(t *testing.T) {
+	done := make(chan error, 1)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) error {
+		c, err := (&Upgrader{}).Upgrade(w, req)
+		if err != nil {
+			return err
+		}
+		err = c.WriteMessage(TextMessage, []byte("going away"))
+		if err == nil {
+			err = c.Close(CloseGoingAway, "shutting down")
+		}
+		done <- err
+		return nil
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	c, _ := dial(t, fmt.Sprintf("localhost:%d", s.Listener.Addr().(*net.TCPAddr).Port), "")
+	require.NotNil(t, c)
+
+	// Test: Close waits for the client's close frame
+	_, msg, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "going away", string(msg))
+	_, _, err = c.ReadMessage()
+	assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: "shutting down"}, err)
+	assert.NoError(t, <-done)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	addr := echoServer(t, &Upgrader{ReadLimit: 16}, nil)
+	tests := []struct {
+		name string
+		send func(c *Conn)
+		code int
+	}{
+		{"unmasked frame", func(c *Conn) { c.conn.Write([]byte("\x81\x02hi")) }, CloseProtocolError},
+		{"reserved opcode", func(c *Conn) { writeRaw(t, c, 0x3, true, nil) }, CloseProtocolError},
+		{"reserved bits", func(c *Conn) { writeRaw(t, c, 0x20|opText, true, []byte("hi")) }, CloseProtocolError},
+		{"continuation without a message", func(c *Conn) { writeRaw(t, c, opContinuation, true, []byte("hi")) }, CloseProtocolError},
+		{"new message inside a fragmented one", func(c *Conn) {
+			writeRaw(t, c, opText, false, []byte("a"))
+			writeRaw(t, c, opText, true, []byte("b"))
+		}, CloseProtocolError},
+		{"fragmented ping", func(c *Conn) { writeRaw(t, c, opPing, false, nil) }, CloseProtocolError},
+		{"oversized ping", func(c *Conn) { writeRaw(t, c, opPing, true, make([]byte, 126)) }, CloseProtocolError},
+		{"compressed frame without the extension", func(c *Conn) { writeRaw(t, c, 0x40|opText, true, []byte("hi")) }, CloseProtocolError},
+		{"invalid close code", func(c *Conn) { writeRaw(t, c, opClose, true, []byte{0x03, 0xed}) }, CloseProtocolError},
+		{"invalid UTF-8", func(c *Conn) { writeRaw(t, c, opText, true, []byte{'h', 0xc3}) }, CloseInvalidPayload},
+		{"invalid UTF-8 across fragments", func(c *Conn) {
+			writeRaw(t, c, opText, false, []byte{0xc3})
+			writeRaw(t, c, opContinuation, true, []byte{'x'})
+		}, CloseInvalidPayload},
+		{"message over the limit", func(c *Conn) { c.WriteMessage(BinaryMessage, make([]byte, 17)) }, CloseMessageTooBig},
+		{"fragments over the limit", func(c *Conn) {
+			writeRaw(t, c, opBinary, false, make([]byte, 10))
+			writeRaw(t, c, opContinuation, true, make([]byte, 10))
+		}, CloseMessageTooBig},
+		{"close without a code", func(c *Conn) { writeRaw(t, c, opClose, true, nil) }, CloseNormal},
+	}
+	for _, tt := range tests {
+		c, _ := dial(t, addr, "")
+		require.NotNil(t, c, tt.name)
+		tt.send(c)
+		assert.Equal(t, tt.code, closeCode(t, c), tt.name)
+	}
+
+	// Test: Split UTF-8 sequences are valid once reassembled
+	c, _ := dial(t, addr, "")
+	require.NotNil(t, c)
+	writeRaw(t, c, opText, false, []byte{'h', 0xc3})
+	writeRaw(t, c, opContinuation, true, []byte{0xa9})
+	_, msg, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "hé", string(msg))
+}
+
+func TestCompression(t *testing.T) {
+	addr := echoServer(t, &Upgrader{EnableCompression: true, ReadLimit: 1 << 10}, nil)
+
+	// Test: Offers the server cannot honour are declined
+	c, res := dial(t, addr, "Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10, x-webkit-deflate-frame\r\n")
+	require.NotNil(t, c)
+	assert.Empty(t, res.Header.Get("Sec-WebSocket-Extensions"))
+
+	// Test: Messages are compressed both ways
+	c, res = dial(t, addr, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits, permessage-deflate\r\n")
+	require.NotNil(t, c)
+	assert.Equal(t, deflateResponse, res.Header.Get("Sec-WebSocket-Extensions"))
+	for _, msg := range []string{"", "a", strings.Repeat("compress me ", 80)} {
+		require.NoError(t, c.WriteMessage(TextMessage, []byte(msg)))
+		h, err := c.readFrameHeader()
+		require.NoError(t, err)
+		assert.True(t, h.rsv1)
+		p, err := c.readPayload(h)
+		require.NoError(t, err)
+		if len(msg) > 100 {
+			assert.Less(t, len(p), len(msg)/4)
+		}
+		got, err := inflate(p, 1<<10)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(got))
+	}
+
+	// Test: Uncompressed messages are still accepted
+	c.compress = false
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("plain")))
+	c.compress = true
+	_, got, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(got))
+
+	// Test: The limit applies to decompressed messages
+	require.NoError(t, c.WriteMessage(BinaryMessage, make([]byte, 1<<10+1)))
+	assert.Equal(t, CloseMessageTooBig, closeCode(t, c))
+}